@@ -1,25 +1,79 @@
 package webkitgtk
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+	"unicode"
 )
 
-func apiClient(bindings map[string]apiBinding) string {
-	calls := make(map[string][]string)
-	for api, binding := range bindings {
-		for fn := range binding {
-			calls[api] = append(calls[api], fn)
+type apiClientCall struct {
+	Path   string // Path is the path of the stub below window
+	Name   string
+	Stream bool
+}
+
+type apiClientAPI struct {
+	Name       string
+	Object     bool     // Object indicates the api is an object of functions rather than a function
+	Namespaces []string // Namespaces are the paths of the nested objects, parents first
+	Calls      []apiClientCall
+}
+
+// apiClient returns the client script of the bindings. The token authenticates the messages
+// of the client, it is only known to the page the client is injected into and not to the
+// frames embedded in it, which can post messages to the handlers as well.
+func apiClient(bindings map[string]apiBinding, token string) string {
+	apis := make([]apiClientAPI, 0, len(bindings))
+	for name, binding := range bindings {
+		api := apiClientAPI{Name: name}
+		seen := make(map[string]bool)
+		for fn, method := range binding {
+			call := apiClientCall{Path: name, Name: fn, Stream: method.stream}
+			if fn != "" {
+				api.Object = true
+				call.Path += "." + fn
+			}
+			api.Calls = append(api.Calls, call)
+			for i, c := range fn {
+				if c == '.' && !seen[fn[:i]] {
+					seen[fn[:i]] = true
+					api.Namespaces = append(api.Namespaces, name+"."+fn[:i])
+				}
+			}
 		}
+		sort.Slice(api.Calls, func(i, j int) bool {
+			return api.Calls[i].Name < api.Calls[j].Name
+		})
+		sort.Strings(api.Namespaces) // parents sort before their children
+		apis = append(apis, api)
 	}
+	sort.Slice(apis, func(i, j int) bool {
+		return apis[i].Name < apis[j].Name
+	})
 	var buf strings.Builder
 	if err := apiClientTmpl.Execute(&buf, struct {
-		Calls map[string][]string
+		APIs    []apiClientAPI
+		BlobURL string
+		Version int
+		Token   string
 	}{
-		Calls: calls,
+		APIs:    apis,
+		BlobURL: uriScheme + "://" + apiBlobHost + "/",
+		Version: apiProtocolVersion,
+		Token:   token,
 	}); err != nil {
 		panic(err)
 	}
@@ -27,152 +81,844 @@ func apiClient(bindings map[string]apiBinding) string {
 }
 
 var apiClientTmpl = template.Must(template.New("api.js").Parse(`(function(document,window) {
+const blobURL = "{{.BlobURL}}";
+const token = "{{.Token}}";
+function cancel(id) {
+	window.webkit.messageHandlers.cancel.postMessage(JSON.stringify({token: token, id: id}));
+}
+function isBinary(v) {
+	return v instanceof ArrayBuffer || ArrayBuffer.isView(v) || v instanceof Blob;
+}
+function toError(e) {
+	let err = new Error(e.message);
+	err.name = e.name;
+	if (e.code !== undefined) err.code = e.code;
+	if (e.data !== undefined) err.data = e.data;
+	return err;
+}
+function upload(v) {
+	return fetch(blobURL+"upload", {method: "POST", body: v}).then((res) => {
+		if (!res.ok) throw new Error("upload failed: "+res.status);
+		return res.text();
+	}).then((token) => ({$blob: token}));
+}
 class WebkitAPI {
 	constructor() {
 		this._id = 0;
 		this._calls = new Map();
+		this._streams = new Map();
+		this._listeners = new Map();
+	}
+	on(name, fn) {
+		if (!this._listeners.has(name)) this._listeners.set(name, []);
+		this._listeners.get(name).push(fn);
+		return () => this.off(name, fn);
+	}
+	off(name, fn) {
+		let listeners = this._listeners.get(name);
+		if (!listeners) return;
+		if (fn === undefined) {
+			this._listeners.delete(name);
+			return;
+		}
+		let i = listeners.findIndex((l) => l === fn || l._fn === fn);
+		if (i >= 0) listeners.splice(i, 1);
+		if (listeners.length === 0) this._listeners.delete(name);
+	}
+	once(name, fn) {
+		let self = this;
+		let wrapper = function(data) {
+			self.off(name, wrapper);
+			fn(data);
+		};
+		wrapper._fn = fn;
+		this.on(name, wrapper);
+		return () => this.off(name, wrapper);
+	}
+	emit(name, data) {
+		let listeners = this._listeners.get(name);
+		if (!listeners) return;
+		for (const fn of listeners.slice()) {
+			try {
+				fn(data);
+			} catch (e) {
+				console.error(e);
+			}
+		}
+	}
+	receive(msg) {
+		if (msg.v !== {{.Version}}) {
+			console.error("webkitAPI: unsupported protocol version "+msg.v);
+			return;
+		}
+		switch (msg.type) {
+		case "resolve":
+			this.resolve(msg.id, msg.result);
+			break;
+		case "blob":
+			this.resolveBlob(msg.id, msg.token, msg.blob);
+			break;
+		case "reject":
+			this.reject(msg.id, toError(msg.error));
+			break;
+		case "push":
+			this.push(msg.id, msg.result);
+			break;
+		case "end":
+			this.end(msg.id, msg.error && toError(msg.error));
+			break;
+		}
 	}
 	resolve(id, data) {
-		this._calls.get(id)[0](JSON.parse(data));
+		let call = this._calls.get(id);
+		if (!call) return;
 		this._calls.delete(id);
+		call[0](data);
+	}
+	resolveBlob(id, token, blob) {
+		let call = this._calls.get(id);
+		if (!call) return;
+		this._calls.delete(id);
+		fetch(blobURL+token).then((res) => {
+			if (!res.ok) throw new Error("download failed: "+res.status);
+			return blob ? res.blob() : res.arrayBuffer();
+		}).then(call[0], call[1]);
 	}
 	reject(id, err) {
-		this._calls.get(id)[1](err);
+		let stream = this._streams.get(id);
+		if (stream) return stream.end(err);
+		let call = this._calls.get(id);
+		if (!call) return;
 		this._calls.delete(id);
+		call[1](err);
+	}
+	push(id, data) {
+		let stream = this._streams.get(id);
+		if (stream) stream.push(data);
 	}
-	request(api, fn, obj) {
+	end(id, err) {
+		let stream = this._streams.get(id);
+		if (stream) stream.end(err);
+	}
+	send(id, api, fn, args) {
+		let post = (args) => window.webkit.messageHandlers.api.postMessage(JSON.stringify({v: {{.Version}}, token: token, id: id, api: api, fn: fn, args: args}));
+		if (!args.some(isBinary)) {
+			post(args);
+			return Promise.resolve();
+		}
+		return Promise.all(args.map((arg) => isBinary(arg) ? upload(arg) : arg)).then((args) => {
+			if (this._calls.has(id) || this._streams.has(id)) post(args);
+		});
+	}
+	stream(api, fn, args) {
+		let self = this;
+		let id = this._id++;
+		let items = [], waiting = [], done = false, error;
+		let settle = () => {
+			while (waiting.length > 0 && (items.length > 0 || done)) {
+				let [resolve, reject] = waiting.shift();
+				if (items.length > 0) {
+					resolve({value: items.shift(), done: false});
+				} else if (error !== undefined) {
+					reject(error);
+					error = undefined;
+				} else {
+					resolve({value: undefined, done: true});
+				}
+			}
+		};
+		self._streams.set(id, {
+			push(data) {
+				items.push(data);
+				settle();
+			},
+			end(err) {
+				done = true;
+				error = err;
+				self._streams.delete(id);
+				settle();
+			},
+		});
+		self.send(id, api, fn, args).catch((err) => self.end(id, err));
+		return {
+			[Symbol.asyncIterator]() {
+				return this;
+			},
+			next() {
+				return new Promise((resolve, reject) => {
+					waiting.push([resolve, reject]);
+					settle();
+				});
+			},
+			return() {
+				if (!done) {
+					done = true;
+					self._streams.delete(id);
+					cancel(id);
+				}
+				items = [];
+				settle();
+				return Promise.resolve({value: undefined, done: true});
+			},
+		};
+	}
+	request(api, fn, args) {
+		let signal;
+		if (args.length > 0 && args[args.length-1] instanceof AbortSignal) signal = args.pop();
+		if (signal && signal.aborted) return Promise.reject(signal.reason);
 		let id = this._id++;
 		let self = this;
-		let msg = id.toString()+" "+api+" "+fn;
-		if (obj) msg += " "+JSON.stringify(obj);
 		return new Promise((resolve, reject) => {
 			self._calls.set(id, [resolve, reject]);
-			window.webkit.messageHandlers.api.postMessage(msg);
+			if (signal) signal.addEventListener("abort", () => {
+				if (!self._calls.has(id)) return;
+				self._calls.delete(id);
+				cancel(id);
+				reject(signal.reason);
+			}, {once: true});
+			self.send(id, api, fn, args).catch((err) => self.reject(id, err));
 		});
 	}
 }
 window.webkitAPI = new WebkitAPI();
-{{range $api, $calls := .Calls}}window.{{$api}} = {};
-{{range $calls}}window.{{$api}}.{{.}} = (obj) => window.webkitAPI.request("{{$api}}", "{{.}}", obj);{{end}}{{end}}
+{{range .APIs}}{{$api := .Name}}{{if .Object}}window.{{$api}} = {};
+{{end}}{{range .Namespaces}}window.{{.}} = {};
+{{end}}{{range .Calls}}window.{{.Path}} = (...args) => window.webkitAPI.{{if .Stream}}stream{{else}}request{{end}}("{{$api}}", "{{.Name}}", args);{{end}}
+{{end}}
 })(document.cloneNode(),globalThis.window);`))
 
-func apiHandler(bindings map[string]apiBinding, eval func(string), log func(interface{}, ...interface{})) func(string) {
-	return func(req string) {
-		var id, api, fn string
-		var cur int
-		for i, c := range req {
-			if c == ' ' {
-				if id == "" {
-					id = req[:i]
-					cur = i + 1
-				} else if api == "" {
-					api = req[cur:i]
-					cur = i + 1
-				} else {
-					fn = req[cur:i]
-					cur = i + 1
-					break
-				}
-			}
+// apiCalls tracks the cancel functions of running api calls by request id. Ids are only
+// unique per page load, so entries are compared by identity when a call is done.
+type apiCalls struct {
+	mutex sync.Mutex
+	calls map[uint64]*apiCall
+}
+
+// apiCall is the entry of a running call.
+type apiCall struct {
+	cancel context.CancelFunc
+}
+
+func (c *apiCalls) add(id uint64, cancel context.CancelFunc) *apiCall {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[uint64]*apiCall)
+	}
+	call := &apiCall{cancel: cancel}
+	c.calls[id] = call
+	return call
+}
+
+// done removes the entry of the call unless it was replaced by a call of a new page.
+func (c *apiCalls) done(id uint64, call *apiCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.calls[id] == call {
+		delete(c.calls, id)
+	}
+}
+
+// reset drops the entries of the previous page, whose calls are canceled with its context.
+func (c *apiCalls) reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = nil
+}
+
+func (c *apiCalls) cancel(id uint64) {
+	c.mutex.Lock()
+	call, ok := c.calls[id]
+	delete(c.calls, id)
+	c.mutex.Unlock()
+	if ok {
+		call.cancel()
+	}
+}
+
+// apiCancelHandler cancels the context of the call with the id sent when the JS caller aborts.
+func apiCancelHandler(w *Window) func(string) {
+	log := w.log
+	return func(msg string) {
+		var req apiCancel
+		if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			log.Warn("api cancel error", "error", "invalid request", "request", msg)
+			return
+		}
+		if !w.apiTokenValid(req.Token) {
+			log.Warn("api cancel error", "error", "invalid token", "id", req.ID)
+			return
+		}
+		log.Debug("api cancel", "id", req.ID)
+		w.calls.cancel(req.ID)
+	}
+}
+
+// apiToken returns a random token, e.g. to authenticate the api client of a page.
+func apiToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// apiTokenValid reports if the token is the token of the client injected into the current
+// page. Must be called on the main thread.
+func (w *Window) apiTokenValid(token string) bool {
+	return w.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) == 1
+}
+
+// evalAPI evaluates a script of the api bridge, e.g. a reply or an event, if the client
+// is injected into the current page. If token is not empty, the script is only evaluated
+// if the page the token was issued for is still loaded.
+func (w *Window) evalAPI(token string, js string) {
+	w.invoke(func() {
+		if w.webview == 0 || w.token == "" || (token != "" && token != w.token) {
+			return
+		}
+		windowExecJS(w.webview, js)
+	})
+}
+
+// apiHandler handles the api requests of the window. Calls pass through the handler chain
+// built by apiChain. The context of each call is derived from the page context of the
+// window, which is canceled when the page or the app goes away.
+func apiHandler(w *Window, handler CallHandler) func(string) {
+	log := w.log
+	return func(msg string) {
+		var req apiRequest
+		if err := json.Unmarshal([]byte(msg), &req); err != nil {
+			log.Warn("api error", "error", "invalid request", "request", msg)
+			return
+		}
+		if !w.apiTokenValid(req.Token) {
+			// the message was not sent by the client of the page, e.g. by an embedded frame,
+			// replies would reach the calls of the page
+			log.Warn("api error", "error", "invalid token", "api", req.API, "fn", req.Fn)
+			return
 		}
-		if fn == "" {
-			fn = req[cur:]
-			cur = len(req)
+		token := req.Token
+		eval := func(js string) {
+			w.evalAPI(token, js)
 		}
-		if id == "" || api == "" || fn == "" {
-			log("api error", "error", "invalid request", "request", req)
+		if req.V != apiProtocolVersion {
+			apiReject(eval, log, req.ID, &CallError{
+				Code: CodeUnsupportedVersion,
+				Err:  fmt.Errorf("unsupported protocol version %d, expected %d", req.V, apiProtocolVersion),
+			})
 			return
 		}
 
-		log("api request", "id", id, "api", api, "fn", fn)
-		binding, ok := bindings[api]
+		origin := apiOrigin(lib.webkit.WebViewGetUri(w.webview))
+		if !apiOriginAllowed(w.options.AllowedOrigins, origin) {
+			// the client is not injected into disallowed pages, so there is no one to reply to
+			log.Warn("api error", "error", "origin not allowed", "origin", origin, "api", req.API, "fn", req.Fn)
+			return
+		}
+
+		log.Debug("api request", "id", req.ID, "api", req.API, "fn", req.Fn)
+		binding, ok := w.bindings[req.API]
 		if !ok {
-			eval("webkitAPI.reject(" + string(id) + ",'api not found')")
+			apiReject(eval, log, req.ID, &CallError{Code: CodeNotFound, Err: fmt.Errorf("api %s not found", req.API)})
 			return
 		}
-		go func() {
-			reply, err := binding.call(fn, req[cur:])
+		method, err := binding.method(req.Fn)
+		if err != nil {
+			apiReject(eval, log, req.ID, &CallError{Code: CodeNotFound, Err: err})
+			return
+		}
+		call := &Call{
+			ID:     req.ID,
+			Window: w,
+			Origin: origin,
+			API:    req.API,
+			Method: req.Fn,
+			Args:   req.Args,
+			method: method,
+			blobs:  &w.app.blobs,
+		}
+		callCtx, cancel := context.WithCancel(w.ctx)
+		entry := w.calls.add(req.ID, cancel)
+		w.app.inflight.add()
+		release := func() {
+			w.calls.done(req.ID, entry)
+			cancel()
+			w.app.inflight.done()
+		}
+		method.pool.submit(func() {
+			// a stream is pumped on its own goroutine, which releases the call once it ends,
+			// so the worker is free as soon as the method returned the channel
+			var streaming bool
+			defer func() {
+				if !streaming {
+					release()
+				}
+			}()
+			if callCtx.Err() != nil {
+				log.Debug("api canceled while queued", "id", req.ID, "error", callCtx.Err())
+				return
+			}
+			ctx := callCtx
+			if timeout := method.timeout(w.options.APITimeout); timeout > 0 {
+				var cancelTimeout context.CancelFunc
+				ctx, cancelTimeout = context.WithTimeout(callCtx, timeout)
+				defer cancelTimeout()
+				// reject right away, the method may return late if it ignores its context
+				stop := context.AfterFunc(ctx, func() {
+					if ctx.Err() == context.DeadlineExceeded && callCtx.Err() == nil {
+						apiReject(eval, log, req.ID, &CallError{
+							Name: "TimeoutError",
+							Code: CodeTimeout,
+							Err:  fmt.Errorf("call timed out after %s", timeout),
+						})
+					}
+				})
+				defer stop()
+			}
+			result, err := handler(ctx, call)
+			if ctx.Err() != nil {
+				log.Debug("api canceled", "id", req.ID, "error", ctx.Err())
+				return
+			}
 			if err != nil {
-				log("api reject", "id", id, "error", err)
-				eval("webkitAPI.reject(" + string(id) + ",'" + err.Error() + "')")
+				apiReject(eval, log, req.ID, err)
+				return
+			}
+			output := reflect.ValueOf(result)
+			if method.stream {
+				streaming = true
+				go func() {
+					defer release()
+					apiStream(callCtx, req.ID, output, eval, log)
+				}()
 				return
 			}
-			log("api resolve", "id", id, "reply", reply)
-			eval("webkitAPI.resolve(" + id + ",'" + reply + "')")
-		}()
+			reply := apiMessage{ID: req.ID, Type: "resolve"}
+			if output.IsValid() {
+				if msg, ok := call.blobs.encode(output); ok {
+					log.Debug("api resolve blob", "id", req.ID)
+					msg.ID = req.ID
+					apiSend(eval, log, msg)
+					return
+				}
+				if reply.Result, err = json.Marshal(result); err != nil {
+					apiReject(eval, log, req.ID, &CallError{Code: CodeEncoding, Err: err})
+					return
+				}
+			} else if method.hasOutput {
+				reply.Result = json.RawMessage("null")
+			}
+			log.Debug("api resolve", "id", req.ID, "reply", string(reply.Result))
+			apiSend(eval, log, reply)
+		})
 	}
 }
 
-type apiBinding map[string]func(string) (string, error)
+// apiStream pushes the values received from the channel to the async iterator of the call
+// until the channel is closed or the call is canceled. A canceled stream is drained in the
+// background so a producer ignoring the context does not block forever.
+func apiStream(ctx context.Context, id uint64, ch reflect.Value, eval func(string), log *slog.Logger) {
+	if !ch.IsValid() || ch.Kind() != reflect.Chan || ch.IsNil() {
+		apiSend(eval, log, apiMessage{ID: id, Type: "end"})
+		return
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: ch},
+	}
+	for {
+		chosen, value, ok := reflect.Select(cases)
+		if chosen == 0 {
+			log.Debug("api stream canceled", "id", id, "error", ctx.Err())
+			go apiDrain(ch)
+			return
+		}
+		if !ok {
+			log.Debug("api stream end", "id", id)
+			apiSend(eval, log, apiMessage{ID: id, Type: "end"})
+			return
+		}
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			log.Warn("api stream error", "id", id, "error", err)
+			apiSend(eval, log, apiMessage{ID: id, Type: "end", Error: newAPIError(&CallError{Code: CodeEncoding, Err: err})})
+			go apiDrain(ch)
+			return
+		}
+		apiSend(eval, log, apiMessage{ID: id, Type: "push", Result: data})
+	}
+}
+
+// apiDrain receives from the channel until it is closed.
+func apiDrain(ch reflect.Value) {
+	for {
+		if _, ok := ch.Recv(); !ok {
+			return
+		}
+	}
+}
+
+type apiBinding map[string]*apiMethod
+
+// apiMethod is a method callable from JavaScript.
+type apiMethod struct {
+	value      reflect.Value // value is the bound method
+	hasContext bool          // hasContext indicates the first parameter is a context.Context
+	hasOutput  bool          // hasOutput indicates a value is returned besides the error
+	stream     bool          // stream indicates the returned channel is streamed to JavaScript
+	policy     *CallPolicy   // policy is the policy of the bound struct, nil for the defaults
+	pool       *apiPool      // pool runs the calls, assigned when the binding is used by a window
+}
 
+// timeout returns the timeout of the calls, 0 if they have none.
+func (m *apiMethod) timeout(fallback time.Duration) time.Duration {
+	timeout := fallback
+	if m.policy != nil && m.policy.Timeout != 0 {
+		timeout = m.policy.Timeout
+	}
+	if m.stream {
+		return 0 // streams run until the channel is closed
+	}
+	return max(timeout, 0)
+}
+
+var apiContextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// apiBind binds a struct pointer, a func or a map of funcs. The methods of a struct pointer
+// are bound by their name. Exported fields holding struct pointers are bound recursively as
+// nested namespaces, whose functions are named by their dotted path, e.g. "files.list".
+// The name of a namespace is set or hidden with the webkit tag:
+//
+//	Files *FilesAPI `webkit:"fs"`
+//	Admin *AdminAPI `webkit:"-"`
+//	Queue *QueueAPI `webkit:",serial"`
+//
+// The serial option and the APIPolicy interface configure the execution of the calls.
+// Fields holding structs that can not be bound, e.g. references to a parent, fail the
+// binding unless they are hidden. Embedded structs are not bound as namespaces, their
+// methods are promoted to the struct.
+// A func is bound with an empty name and the entries of a map by their key, where map
+// values may again be struct pointers or maps.
 func apiBind(api interface{}) (apiBinding, error) {
 	value := reflect.ValueOf(api)
-	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
-		return nil, fmt.Errorf("api is not a struct pointer")
+	switch {
+	case value.Kind() == reflect.Func:
+		m, err := apiBindFunc(value, "")
+		if err != nil {
+			return nil, err
+		}
+		return apiBinding{"": m}, nil
+	case value.Kind() == reflect.Map:
+		return apiBindMap(value, nil)
+	case value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct:
+		return apiBindValue(value, nil, false)
 	}
+	return nil, fmt.Errorf("api is not a struct pointer, func or map of funcs")
+}
+
+// apiBindable reports if the Define value is bound by apiBind instead of being encoded as
+// a constant. Maps are bound if their values are funcs, struct pointers or bindable maps.
+func apiBindable(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.Func:
+		return true
+	case reflect.Ptr:
+		return value.Elem().Kind() == reflect.Struct
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		switch elem := value.Type().Elem(); elem.Kind() {
+		case reflect.Func:
+			return true
+		case reflect.Ptr:
+			return elem.Elem().Kind() == reflect.Struct
+		case reflect.Interface, reflect.Map:
+			iter := value.MapRange()
+			for iter.Next() {
+				if elem := iter.Value(); elem.IsNil() || !apiBindable(elem.Interface()) {
+					return false
+				}
+			}
+			return value.Len() > 0
+		}
+	}
+	return false
+}
+
+// apiBindFunc checks the signature of the func and returns the method calling it.
+func apiBindFunc(fn reflect.Value, name string) (*apiMethod, error) {
+	function := "function"
+	if name != "" {
+		function += " " + name
+	}
+	if fn.IsNil() {
+		return nil, fmt.Errorf("%s is nil", function)
+	}
+	m := &apiMethod{value: fn}
+	if fn.Type().NumIn() > 0 && fn.Type().In(0) == apiContextType {
+		m.hasContext = true
+	}
+	outputCount := fn.Type().NumOut()
+	if outputCount < 1 || !fn.Type().Out(outputCount-1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return nil, fmt.Errorf("%s requires an error as last output", function)
+	}
+	if outputCount > 1 {
+		if outputCount > 2 {
+			return nil, fmt.Errorf("%s has too many outputs", function)
+		}
+		m.hasOutput = true
+		output := fn.Type().Out(0)
+		m.stream = output.Kind() == reflect.Chan && output.ChanDir()&reflect.RecvDir != 0
+	}
+	return m, nil
+}
+
+// apiBindMap binds the funcs of the map by their key and its struct pointers and maps as
+// nested namespaces.
+func apiBindMap(value reflect.Value, parents []uintptr) (apiBinding, error) {
+	if value.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("api map keys are not strings")
+	}
+	parents = append(parents, value.Pointer())
 	binding := make(apiBinding)
-	for i := 0; i < value.NumMethod(); i++ {
-		method := value.Method(i)
+	iter := value.MapRange()
+	for iter.Next() {
+		name := iter.Key().String()
+		if !apiValidName(name) {
+			return nil, fmt.Errorf("function %s is not a valid identifier", name)
+		}
+		elem := iter.Value()
+		if elem.Kind() == reflect.Interface {
+			elem = elem.Elem()
+		}
+		var nested apiBinding
+		var err error
+		switch {
+		case elem.Kind() == reflect.Func:
+			var m *apiMethod
+			if m, err = apiBindFunc(elem, name); err != nil {
+				return nil, err
+			}
+			binding[name] = m
+			continue
+		case elem.Kind() == reflect.Map:
+			if slices.Contains(parents, elem.Pointer()) {
+				return nil, fmt.Errorf("namespace %s refers to its parent", name)
+			}
+			nested, err = apiBindMap(elem, parents)
+		case elem.Kind() == reflect.Ptr && !elem.IsNil() && elem.Elem().Kind() == reflect.Struct:
+			nested, err = apiBindValue(elem, parents, false)
+		default:
+			return nil, fmt.Errorf("%s is not a function, struct pointer or map of functions", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("namespace %s: %w", name, err)
+		}
+		for fn, m := range nested {
+			binding[name+"."+fn] = m
+		}
+	}
+	return binding, nil
+}
+
+// apiBindValue binds the struct pointer value. Parents are the struct pointers the value is
+// nested in, to detect cycles.
+func apiBindValue(value reflect.Value, parents []uintptr, serial bool) (apiBinding, error) {
+	var policy *CallPolicy
+	apiPolicy, hasPolicy := value.Interface().(APIPolicy)
+	if hasPolicy {
+		p := apiPolicy.CallPolicy()
+		policy = &p
+	}
+	if serial {
+		if policy == nil {
+			policy = &CallPolicy{}
+		}
+		policy.Serial = true
+	}
 
-		fn := value.Type().Method(i).Name
-		fn = strings.ToLower(string(fn[0])) + fn[1:]
+	binding := make(apiBinding)
+	for i := 0; i < value.NumMethod(); i++ {
+		if hasPolicy && value.Type().Method(i).Name == "CallPolicy" {
+			continue
+		}
+		fn := apiName(value.Type().Method(i).Name)
 		if _, exists := binding[fn]; exists {
 			return nil, fmt.Errorf("function %s already exists", fn)
 		}
+		m, err := apiBindFunc(value.Method(i), fn)
+		if err != nil {
+			return nil, err
+		}
+		m.policy = policy
+		binding[fn] = m
+	}
 
-		var hasInput, hasOutput bool
-		var inputType reflect.Type
-		inputCount := method.Type().NumIn()
-		if inputCount > 0 {
-			if inputCount > 1 {
-				return nil, fmt.Errorf("function has too many inputs")
-			}
-			hasInput = true
-			inputType = method.Type().In(0)
+	parents = append(parents, value.Pointer())
+	t := value.Elem().Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Anonymous || field.Type.Kind() != reflect.Ptr || field.Type.Elem().Kind() != reflect.Struct {
+			continue
+		}
+		tag := field.Tag.Get("webkit")
+		if tag == "-" {
+			continue
 		}
-		outputCount := method.Type().NumOut()
-		if outputCount < 1 || !method.Type().Out(outputCount-1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
-			return nil, fmt.Errorf("function requires an error as last output")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = apiName(field.Name)
+		} else if !apiValidName(name) {
+			return nil, fmt.Errorf("namespace %s is not a valid identifier", name)
 		}
-		if outputCount > 1 {
-			if outputCount > 2 {
-				return nil, fmt.Errorf("function has too many outputs")
+		fieldValue := value.Elem().Field(i)
+		if fieldValue.IsNil() {
+			continue
+		}
+		var nested apiBinding
+		var err error
+		if slices.Contains(parents, fieldValue.Pointer()) {
+			err = fmt.Errorf("namespace refers to its parent")
+		} else {
+			nested, err = apiBindValue(fieldValue, parents, slices.Contains(strings.Split(opts, ","), "serial"))
+		}
+		if err != nil {
+			var fieldErr *apiFieldError
+			if tag == "" && !errors.As(err, &fieldErr) {
+				err = &apiFieldError{field: field.Name, err: err}
 			}
-			hasOutput = true
+			return nil, fmt.Errorf("namespace %s: %w", name, err)
+		}
+		if len(nested) == 0 {
+			continue
 		}
+		if _, exists := binding[name]; exists {
+			return nil, fmt.Errorf("namespace %s conflicts with function %s", name, name)
+		}
+		for fn, m := range nested {
+			binding[name+"."+fn] = m
+		}
+	}
+	return binding, nil
+}
 
-		binding[fn] = func(s string) (string, error) {
-			var inputs []reflect.Value
-			if hasInput {
-				input := reflect.New(inputType).Elem()
-				if err := json.Unmarshal([]byte(s), input.Addr().Interface()); err != nil {
-					return "", err
-				}
-				inputs = []reflect.Value{input}
+// apiFieldError is the error of an untagged field that could not be bound as namespace.
+type apiFieldError struct {
+	field string
+	err   error
+}
+
+func (e *apiFieldError) Error() string {
+	return e.err.Error() + ", hide the field " + e.field + " with the tag webkit:\"-\""
+}
+
+func (e *apiFieldError) Unwrap() error {
+	return e.err
+}
+
+// apiName returns the JavaScript name of an exported Go identifier.
+func apiName(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// apiValidName reports if the name is a JavaScript identifier, so it can be used in the
+// generated client without quoting.
+func apiValidName(name string) bool {
+	for i, r := range name {
+		if r != '_' && r != '$' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// call calls the method with the JSON encoded arguments. The returned value is invalid
+// if the method only returns an error.
+func (m *apiMethod) call(ctx context.Context, args string, blobs *apiBlobs) (reflect.Value, error) {
+	var inputs []reflect.Value
+	if m.hasContext {
+		inputs = append(inputs, reflect.ValueOf(&ctx).Elem())
+	}
+	decoded, err := apiDecodeArgs(m.value.Type(), len(inputs), args, blobs)
+	if err != nil {
+		return reflect.Value{}, &CallError{Code: CodeInvalidArguments, Err: err}
+	}
+	outputs := m.value.Call(append(inputs, decoded...))
+	if err := outputs[len(outputs)-1].Interface(); err != nil {
+		return reflect.Value{}, err.(error)
+	}
+	if m.hasOutput {
+		return outputs[0], nil
+	}
+	return reflect.Value{}, nil
+}
+
+// apiDecodeArgs decodes the JSON array of arguments into the parameters of the function type
+// starting at the parameter with index first. Missing arguments are set to their zero value,
+// additional arguments are passed to a variadic parameter. Blob references are resolved
+// from blobs if not nil.
+func apiDecodeArgs(t reflect.Type, first int, s string, blobs *apiBlobs) ([]reflect.Value, error) {
+	var args []json.RawMessage
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	} else if len(args) > fixed-first {
+		return nil, fmt.Errorf("too many arguments: expected %d, got %d", fixed-first, len(args))
+	}
+	inputs := make([]reflect.Value, 0, max(fixed-first, len(args)))
+	for i := 0; i < fixed-first; i++ {
+		input := reflect.New(t.In(first + i)).Elem()
+		if i < len(args) {
+			if err := apiDecodeArg(args[i], input, blobs); err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
 			}
-			outputs := method.Call(inputs)
-			if len(outputs) != outputCount {
-				return "", fmt.Errorf("call did not return enought values")
+		}
+		inputs = append(inputs, input)
+	}
+	for i := fixed - first; i < len(args); i++ {
+		input := reflect.New(t.In(fixed).Elem()).Elem()
+		if err := apiDecodeArg(args[i], input, blobs); err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+// apiDecodeArg decodes a single argument. []byte and io.Reader parameters also accept
+// blobs uploaded by the JS client, an io.Reader parameter is otherwise read from a string.
+func apiDecodeArg(raw json.RawMessage, input reflect.Value, blobs *apiBlobs) error {
+	if input.Type() == apiBytesType || input.Type() == apiReaderType {
+		if blobs != nil {
+			if ok, err := blobs.decode(raw, input); ok {
+				return err
 			}
-			if err := outputs[outputCount-1].Interface(); err != nil {
-				return "", err.(error)
+		}
+		if input.Type() == apiReaderType {
+			var s *string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return err
 			}
-			if hasOutput {
-				output, err := json.Marshal(outputs[0].Interface())
-				if err != nil {
-					return "", err
-				}
-				return string(output), nil
+			if s != nil {
+				input.Set(reflect.ValueOf(strings.NewReader(*s)))
 			}
-			return "", nil
+			return nil
 		}
 	}
-	return binding, nil
+	return json.Unmarshal(raw, input.Addr().Interface())
 }
 
-func (api apiBinding) call(name string, input string) (string, error) {
-	if fn, ok := api[name]; ok {
-		return fn(input)
+func (api apiBinding) method(name string) (*apiMethod, error) {
+	if method, ok := api[name]; ok {
+		return method, nil
 	}
-	return "", fmt.Errorf("function %s not found", name)
+	return nil, fmt.Errorf("function %s not found", name)
 }
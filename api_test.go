@@ -0,0 +1,418 @@
+package webkitgtk
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPICallsDoneKeepsNewPageCall(t *testing.T) {
+	var calls apiCalls
+
+	// a call of the previous page returns after a new page reused its id
+	_, cancelOld := context.WithCancel(context.Background())
+	old := calls.add(1, cancelOld)
+	calls.reset()
+	ctx, cancelNew := context.WithCancel(context.Background())
+	defer cancelNew()
+	current := calls.add(1, cancelNew)
+	calls.done(1, old)
+
+	calls.cancel(1)
+	if ctx.Err() == nil {
+		t.Fatal("call of the new page not canceled")
+	}
+	calls.done(1, current)
+	if len(calls.calls) != 0 {
+		t.Fatalf("%d calls left", len(calls.calls))
+	}
+}
+
+type testFilesAPI struct {
+	Parent *testRootAPI // untagged reference to the parent
+}
+
+func (*testFilesAPI) List() ([]string, error) { return nil, nil }
+
+type testRootAPI struct {
+	Files *testFilesAPI
+}
+
+type testBaseAPI struct{}
+
+func (*testBaseAPI) Version() (string, error) { return "1", nil }
+
+type testEmbeddingAPI struct {
+	*testBaseAPI
+	Base *testBaseAPI `webkit:"base"`
+}
+
+func TestAPIBindUntaggedError(t *testing.T) {
+	root := &testRootAPI{Files: &testFilesAPI{}}
+	root.Files.Parent = root
+	_, err := apiBind(root)
+	if err == nil || !strings.Contains(err.Error(), `webkit:"-"`) {
+		t.Fatalf("got error %v, want an error hinting at the webkit tag", err)
+	}
+}
+
+func TestAPIBindEmbedded(t *testing.T) {
+	binding, err := apiBind(&testEmbeddingAPI{testBaseAPI: &testBaseAPI{}, Base: &testBaseAPI{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range binding {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"base.version", "version"}; !slices.Equal(names, want) {
+		t.Fatalf("bound %v, want %v", names, want)
+	}
+}
+
+func TestAPIBindable(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        any
+		bindable bool
+	}{
+		{name: "func", v: func() error { return nil }, bindable: true},
+		{name: "struct pointer", v: &testBaseAPI{}, bindable: true},
+		{name: "map of funcs", v: map[string]func() error{}, bindable: true},
+		{name: "map of struct pointers", v: map[string]*testBaseAPI{"base": {}}, bindable: true},
+		{name: "map of bindables", v: map[string]any{"base": &testBaseAPI{}, "fn": func() error { return nil }}, bindable: true},
+		{name: "map of maps", v: map[string]map[string]any{"ns": {"base": &testBaseAPI{}}}, bindable: true},
+		{name: "nil", v: nil},
+		{name: "nil struct pointer", v: (*testBaseAPI)(nil)},
+		{name: "string", v: "a"},
+		{name: "struct", v: testBaseAPI{}},
+		{name: "map of values", v: map[string]int{"a": 1}},
+		{name: "mixed map", v: map[string]any{"base": &testBaseAPI{}, "a": 1}},
+		{name: "empty map", v: map[string]any{}},
+		{name: "map with int keys", v: map[int]func() error{}},
+	}
+	for _, test := range tests {
+		if got := apiBindable(test.v); got != test.bindable {
+			t.Errorf("%s: bindable is %t, want %t", test.name, got, test.bindable)
+		}
+	}
+}
+
+func TestAPIBindMap(t *testing.T) {
+	cycle := map[string]any{"fn": func() error { return nil }}
+	cycle["self"] = cycle
+
+	tests := []struct {
+		name  string
+		v     any
+		names []string
+		err   string
+	}{
+		{
+			name:  "funcs",
+			v:     map[string]any{"add": func(a, b int) (int, error) { return a + b, nil }, "math": map[string]any{"neg": func(a int) (int, error) { return -a, nil }}},
+			names: []string{"add", "math.neg"},
+		},
+		{
+			name:  "struct pointers",
+			v:     map[string]*testBaseAPI{"base": {}, "other": {}},
+			names: []string{"base.version", "other.version"},
+		},
+		{name: "nil func", v: map[string]any{"fn": (func() error)(nil)}, err: "function fn is nil"},
+		{name: "no error", v: map[string]any{"fn": func() {}}, err: "function fn requires an error as last output"},
+		{name: "invalid name", v: map[string]any{"my-fn": func() error { return nil }}, err: "function my-fn is not a valid identifier"},
+		{name: "nested invalid name", v: map[string]any{"ns": map[string]any{"1fn": func() error { return nil }}}, err: "namespace ns: function 1fn is not a valid identifier"},
+		{name: "cycle", v: cycle, err: "namespace self refers to its parent"},
+		{name: "value", v: map[string]any{"a": 1}, err: "a is not a function, struct pointer or map of functions"},
+		{name: "nil struct pointer", v: map[string]*testBaseAPI{"base": nil}, err: "base is not a function, struct pointer or map of functions"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			binding, err := apiBind(test.v)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for name := range binding {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !slices.Equal(names, test.names) {
+				t.Fatalf("bound %v, want %v", names, test.names)
+			}
+		})
+	}
+}
+
+func TestAPIDecodeArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		fn    any
+		first int // first is 1 for functions taking a context
+		args  string
+		want  []any
+		err   string
+	}{
+		{name: "all", fn: func(int, string) {}, args: `[1,"a"]`, want: []any{1, "a"}},
+		{name: "missing", fn: func(int, string) {}, args: `[1]`, want: []any{1, ""}},
+		{name: "none", fn: func(int, string) {}, want: []any{0, ""}},
+		{name: "too many", fn: func(int) {}, args: `[1,2]`, err: "too many arguments: expected 1, got 2"},
+		{name: "too many with context", fn: func(context.Context, int) {}, first: 1, args: `[1,2]`, err: "too many arguments: expected 1, got 2"},
+		{name: "variadic", fn: func(string, ...int) {}, args: `["a",1,2]`, want: []any{"a", 1, 2}},
+		{name: "variadic empty", fn: func(string, ...int) {}, args: `["a"]`, want: []any{"a"}},
+		{name: "variadic missing", fn: func(string, ...int) {}, args: `[]`, want: []any{""}},
+		{name: "variadic with context", fn: func(context.Context, string, ...int) {}, first: 1, args: `["a",1,2]`, want: []any{"a", 1, 2}},
+		{name: "variadic only with context", fn: func(context.Context, ...int) {}, first: 1, args: `[1,2]`, want: []any{1, 2}},
+		{name: "invalid argument", fn: func(int, string) {}, args: `[1,2]`, err: "argument 1: "},
+		{name: "invalid element", fn: func(string, ...int) {}, args: `["a",1,"b"]`, err: "argument 2: "},
+		{name: "invalid arguments", fn: func(int) {}, args: `{"a":1}`, err: "invalid arguments: "},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			inputs, err := apiDecodeArgs(reflect.TypeOf(test.fn), test.first, test.args, nil)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]any, len(inputs))
+			for i, input := range inputs {
+				got[i] = input.Interface()
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("decoded %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// newTestAPIWindow returns a window showing a page of origin app://main with the client
+// injected, the scripts it evaluates are sent to the returned channel.
+func newTestAPIWindow(t *testing.T, define map[string]any) (*Window, <-chan string) {
+	l := newTestLoop(t)
+	scripts := make(chan string, 16)
+	stubLib(t, &lib.webkit.WebViewGetUri, func(webviewPtr) string {
+		return "app://main/index.html"
+	})
+	stubLib(t, &lib.webkit.WebViewEvaluateJavascript, func(_ webviewPtr, js string, _ int, _, _, _, _, _ ptr) {
+		scripts <- js
+	})
+
+	log := slog.New(discardHandler{})
+	a := &App{log: log, thread: l.mt, windows: make(map[uint]*Window)}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	t.Cleanup(a.cancel)
+	w := &Window{
+		log:      log,
+		app:      a,
+		webview:  1,
+		options:  WindowOptions{AllowedOrigins: []string{"app://"}},
+		bindings: make(map[string]apiBinding),
+	}
+	for name, v := range define {
+		binding, err := apiBind(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.bindings[name] = binding
+	}
+	l.mt.InvokeSync(func() {
+		w.resetContext()
+		w.token = "page"
+	})
+	return w, scripts
+}
+
+func TestAPIHandlerToken(t *testing.T) {
+	var calls atomic.Int32
+	w, scripts := newTestAPIWindow(t, map[string]any{
+		"add": func(a, b int) (int, error) {
+			calls.Add(1)
+			return a + b, nil
+		},
+	})
+	handle := apiHandler(w, apiInvoke)
+	cancel := apiCancelHandler(w)
+
+	// messages posted by frames embedded in the page do not know the token
+	for _, token := range []string{"", "guess"} {
+		msg := `{"v":1,"token":"` + token + `","id":0,"api":"add","fn":"","args":[1,2]}`
+		w.app.thread.InvokeSync(func() {
+			handle(msg)
+			cancel(`{"token":"` + token + `","id":0}`)
+		})
+	}
+	w.app.thread.InvokeSync(func() {
+		handle(`{"v":1,"token":"page","id":1,"api":"add","fn":"","args":[1,2]}`)
+	})
+	select {
+	case js := <-scripts:
+		if want := `webkitAPI.receive({"v":1,"id":1,"type":"resolve","result":3})`; js != want {
+			t.Fatalf("got reply %s, want %s", js, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the call of the page")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("function called %d times, want 1", n)
+	}
+
+	// replies to calls of a page are dropped once it navigated away
+	w.app.thread.InvokeSync(func() {
+		w.resetContext()
+		w.token = "next"
+	})
+	w.evalAPI("page", "reply")
+	w.evalAPI("", "event")
+	if js := <-scripts; js != "event" {
+		t.Fatalf("got %s, want the event", js)
+	}
+}
+
+func TestAPIChain(t *testing.T) {
+	var calls atomic.Int32
+	w, scripts := newTestAPIWindow(t, map[string]any{
+		"add": func(a, b int) (int, error) {
+			calls.Add(1)
+			return a + b, nil
+		},
+	})
+
+	var mutex sync.Mutex
+	var order []string
+	record := func(name string, deny bool) func(next CallHandler) CallHandler {
+		return func(next CallHandler) CallHandler {
+			return func(ctx context.Context, call *Call) (any, error) {
+				mutex.Lock()
+				order = append(order, name)
+				mutex.Unlock()
+				if deny {
+					return nil, errors.New("denied by " + name)
+				}
+				return next(ctx, call)
+			}
+		}
+	}
+	reply := func() string {
+		select {
+		case js := <-scripts:
+			return js
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reply to the call")
+			return ""
+		}
+	}
+
+	tests := []struct {
+		name   string
+		app    []func(next CallHandler) CallHandler
+		window []func(next CallHandler) CallHandler
+		order  []string
+		reply  string
+		calls  int32
+	}{
+		{
+			name:   "order",
+			app:    []func(next CallHandler) CallHandler{record("app1", false), record("app2", false)},
+			window: []func(next CallHandler) CallHandler{record("window1", false), record("window2", false)},
+			order:  []string{"app1", "app2", "window1", "window2"},
+			reply:  `webkitAPI.receive({"v":1,"id":1,"type":"resolve","result":3})`,
+			calls:  1,
+		},
+		{
+			name:   "short circuit",
+			app:    []func(next CallHandler) CallHandler{record("app", false)},
+			window: []func(next CallHandler) CallHandler{record("deny", true), record("window", false)},
+			order:  []string{"app", "deny"},
+			reply:  `webkitAPI.receive({"v":1,"id":1,"type":"reject","error":{"name":"Error","message":"denied by deny"}})`,
+		},
+	}
+	for _, test := range tests {
+		order = nil
+		calls.Store(0)
+		w.app.middleware, w.options.APIMiddleware = test.app, test.window
+		handle := apiHandler(w, apiChain(apiInvoke, w.app.middleware, w.options.APIMiddleware))
+		w.app.thread.InvokeSync(func() {
+			handle(`{"v":1,"token":"page","id":1,"api":"add","fn":"","args":[1,2]}`)
+		})
+		if js := reply(); js != test.reply {
+			t.Errorf("%s: got reply %s, want %s", test.name, js, test.reply)
+		}
+		mutex.Lock()
+		if !slices.Equal(order, test.order) {
+			t.Errorf("%s: middleware ran in order %v, want %v", test.name, order, test.order)
+		}
+		mutex.Unlock()
+		if n := calls.Load(); n != test.calls {
+			t.Errorf("%s: function called %d times, want %d", test.name, n, test.calls)
+		}
+	}
+}
+
+type testSerialAPI struct {
+	ticks chan int
+}
+
+func (*testSerialAPI) CallPolicy() CallPolicy { return CallPolicy{Serial: true} }
+
+func (api *testSerialAPI) Ticks() (<-chan int, error) { return api.ticks, nil }
+
+func (*testSerialAPI) Ping() (string, error) { return "pong", nil }
+
+func TestAPIStreamReleasesWorker(t *testing.T) {
+	api := &testSerialAPI{ticks: make(chan int)}
+	w, scripts := newTestAPIWindow(t, map[string]any{"serial": api})
+	w.bindings["serial"].assignPools(1)
+	handle := apiHandler(w, apiInvoke)
+
+	w.app.thread.InvokeSync(func() {
+		handle(`{"v":1,"token":"page","id":1,"api":"serial","fn":"ticks","args":[]}`)
+		handle(`{"v":1,"token":"page","id":2,"api":"serial","fn":"ping","args":[]}`)
+	})
+	api.ticks <- 1 // the stream is pumped while the next call runs on the worker
+
+	want := map[string]bool{
+		`webkitAPI.receive({"v":1,"id":1,"type":"push","result":1})`:         true,
+		`webkitAPI.receive({"v":1,"id":2,"type":"resolve","result":"pong"})`: true,
+	}
+	for len(want) > 0 {
+		select {
+		case js := <-scripts:
+			if !want[js] {
+				t.Fatalf("unexpected script %s", js)
+			}
+			delete(want, js)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("serial call blocked by a running stream, missing %v", want)
+		}
+	}
+
+	close(api.ticks)
+	if js := <-scripts; js != `webkitAPI.receive({"v":1,"id":1,"type":"end"})` {
+		t.Fatalf("got %s, want the end of the stream", js)
+	}
+	if !w.app.inflight.wait(5 * time.Second) {
+		t.Fatal("stream not released after it ended")
+	}
+}
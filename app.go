@@ -1,11 +1,17 @@
 package webkitgtk
 
 import (
+	"context"
 	"fmt"
-	"github.com/ebitengine/purego"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -15,18 +21,21 @@ func init() {
 	runtime.LockOSThread()
 }
 
-var _app *App
-
 type App struct {
-	log logFunc
+	log    *slog.Logger // log is the logger of the app component
+	logger *slog.Logger // logger is the root logger other components derive from
 
 	id   string // application id e.g. com.github.malivvan.webkitgtk.undefined
 	pid  int    // process id of the application
 	name string // application name e.g. Unnamed Application
 	icon []byte // application icon used to create desktop file
 
-	thread  *mainThread // thread is the mainthread runner
-	pointer ptr         // gtk application pointer
+	desktop *DesktopEntry // desktop is the desktop entry installed on first run
+
+	thread  *mainThread        // thread is the mainthread runner
+	pointer ptr                // gtk application pointer
+	ctx     context.Context    // ctx is canceled when the application shuts down
+	cancel  context.CancelFunc // cancel cancels ctx
 
 	trayIcon []byte    // trayIcon is the system tray icon (if not set icon will be used)
 	trayMenu *TrayMenu // trayMenu is the system tray menu
@@ -37,22 +46,137 @@ type App struct {
 
 	windows     map[uint]*Window // windows is the map of all windows
 	windowsLock sync.RWMutex     // windowsLock is the lock for windows map
+	windowID    uint             // windowID is the last assigned window id
 
-	dialogs     map[uint]interface{} // dialogs is the map of all dialogs
-	dialogsLock sync.RWMutex         // dialogsLock is the lock for dialogs map
+	dialogs     map[uint64]interface{} // dialogs is the map of all open dialogs
+	dialogsLock sync.RWMutex           // dialogsLock is the lock for dialogs map
 
 	handler     map[string]http.Handler // handler is the map of all http handlers
 	handlerLock sync.RWMutex            // handlerLock is the lock for handler map
 
+	timers     map[*Timer]struct{} // timers is the set of active main loop timers
+	timersLock sync.Mutex          // timersLock is the lock for timers set
+
 	webContext   ptr                // webContext is the global webkit web context
 	hold         bool               // hold indicates if the application stays alive after the last window is closed
+	single       bool               // single indicates if only one instance of the application may run
 	ephemeral    bool               // ephemeral is the flag to indicate if the application is ephemeral
 	dataDir      string             // dataDir is the directory where the application data is stored
 	cacheDir     string             // cacheDir is the directory where the application cache is stored
 	cookiePolicy WebkitCookiePolicy // cookiePolicy is the cookie policy for the application
 	cacheModel   WebkitCacheModel   // cacheModel is the cache model for the application
 
-	started deferredRunner // started is the deferred runner for post application startup
+	started  deferredRunner  // started is the deferred runner for post application startup
+	inflight inflightCounter // inflight counts running api calls and dialogs to drain on shutdown
+	blobs    apiBlobs        // blobs are the binary api arguments and results waiting to be transferred
+
+	shutdownTimeout time.Duration                        // shutdownTimeout is the maximum time to wait for in-flight work on shutdown
+	middleware      []func(next CallHandler) CallHandler // middleware wraps the api calls of all windows
+
+	onSecondInstance func(args []string, cwd string) // onSecondInstance is called when a second instance is launched
+	onOpenURL        func(url string)                // onOpenURL is called when the app is launched with a URL of one of its schemes
+	onLock           sync.RWMutex                    // onLock is the lock for onSecondInstance and onOpenURL
+	schemes          []string                        // schemes are the URL schemes handled by the application
+
+	hooks appHooks // hooks are the registered application lifecycle hooks
+}
+
+type appHooks struct {
+	sync.RWMutex
+	startup      []func()
+	shutdown     []func()
+	beforeQuit   []func() bool
+	windowOpened []func(*Window)
+	windowClosed []func(*Window)
+}
+
+// OnStartup registers a hook that is invoked on the main thread once the application
+// has started, before any window opened prior to Run is created.
+func (a *App) OnStartup(hook func()) {
+	a.hooks.Lock()
+	a.hooks.startup = append(a.hooks.startup, hook)
+	a.hooks.Unlock()
+}
+
+// OnShutdown registers a hook that is invoked after the main loop has stopped, before
+// the dbus session and the GTK application are released.
+func (a *App) OnShutdown(hook func()) {
+	a.hooks.Lock()
+	a.hooks.shutdown = append(a.hooks.shutdown, hook)
+	a.hooks.Unlock()
+}
+
+// OnBeforeQuit registers a hook that is invoked on the main thread when the last window
+// is about to close and the application would quit. If any hook returns false the window
+// stays open and the application keeps running.
+func (a *App) OnBeforeQuit(hook func() bool) {
+	a.hooks.Lock()
+	a.hooks.beforeQuit = append(a.hooks.beforeQuit, hook)
+	a.hooks.Unlock()
+}
+
+// OnWindowOpened registers a hook that is invoked on the main thread after a window
+// has been created.
+func (a *App) OnWindowOpened(hook func(*Window)) {
+	a.hooks.Lock()
+	a.hooks.windowOpened = append(a.hooks.windowOpened, hook)
+	a.hooks.Unlock()
+}
+
+// OnWindowClosed registers a hook that is invoked on the main thread after a window
+// has been closed and removed from the application.
+func (a *App) OnWindowClosed(hook func(*Window)) {
+	a.hooks.Lock()
+	a.hooks.windowClosed = append(a.hooks.windowClosed, hook)
+	a.hooks.Unlock()
+}
+
+func (h *appHooks) invokeStartup() {
+	h.RLock()
+	hooks := h.startup
+	h.RUnlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (h *appHooks) invokeShutdown() {
+	h.RLock()
+	hooks := h.shutdown
+	h.RUnlock()
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (h *appHooks) invokeBeforeQuit() bool {
+	h.RLock()
+	hooks := h.beforeQuit
+	h.RUnlock()
+	for _, hook := range hooks {
+		if !hook() {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *appHooks) invokeWindowOpened(w *Window) {
+	h.RLock()
+	hooks := h.windowOpened
+	h.RUnlock()
+	for _, hook := range hooks {
+		hook(w)
+	}
+}
+
+func (h *appHooks) invokeWindowClosed(w *Window) {
+	h.RLock()
+	hooks := h.windowClosed
+	h.RUnlock()
+	for _, hook := range hooks {
+		hook(w)
+	}
 }
 
 func (a *App) Menu(icon []byte) *TrayMenu {
@@ -70,9 +194,6 @@ func (a *App) Handle(host string, handler http.Handler) {
 }
 
 func New(options AppOptions) *App {
-	if _app != nil {
-		return _app
-	} ///////////////////////////
 
 	// Apply defaults
 	if options.ID == "" {
@@ -84,44 +205,140 @@ func New(options AppOptions) *App {
 	if options.Icon == nil {
 		options.Icon = defaultIcon
 	}
+	if options.ShutdownTimeout == 0 {
+		options.ShutdownTimeout = 5 * time.Second
+	}
+
+	if options.Logger == nil {
+		options.Logger = defaultLogger()
+	}
 
 	// Create app
 	app := &App{
-		log:  newLogFunc("app"),
-		pid:  syscall.Getpid(),
-		id:   options.ID,
-		name: options.Name,
-		icon: options.Icon,
+		log:    options.Logger.With("component", "app"),
+		logger: options.Logger,
+		pid:    syscall.Getpid(),
+		id:     options.ID,
+		name:   options.Name,
+		icon:   options.Icon,
+
+		desktop: options.Desktop,
 
 		windows: make(map[uint]*Window),
-		dialogs: make(map[uint]interface{}),
+		dialogs: make(map[uint64]interface{}),
 		handler: make(map[string]http.Handler),
+		timers:  make(map[*Timer]struct{}),
 
 		hold:         options.Hold,
+		single:       options.SingleInstance || len(options.URLSchemes) > 0,
+		schemes:      options.URLSchemes,
 		ephemeral:    options.Ephemeral,
 		dataDir:      options.DataDir,
 		cacheDir:     options.CacheDir,
 		cookiePolicy: options.CookiePolicy,
 		cacheModel:   options.CacheModel,
+
+		shutdownTimeout: options.ShutdownTimeout,
+		middleware:      options.APIMiddleware,
 	}
 
-	/////////////////////////////////////
-	_app = app // !important
 	return app
 }
 
+// addWindow assigns an id and a logger to the window and adds it to the window
+// registry, so it must be called once the window is complete. If a window with the
+// same name is already registered it is returned instead.
+func (a *App) addWindow(w *Window) *Window {
+	a.windowsLock.Lock()
+	defer a.windowsLock.Unlock()
+	if w.options.Name != "" {
+		for _, existing := range a.windows {
+			if existing.options.Name == w.options.Name {
+				return existing
+			}
+		}
+	}
+	a.windowID++
+	w.id = a.windowID
+	w.log = a.logger.With("component", "window-"+strconv.Itoa(int(w.id)))
+	a.windows[w.id] = w
+	return nil
+}
+
+// dataDirectory returns the directory where persistent application data is stored.
+func (a *App) dataDirectory() string {
+	if a.dataDir != "" {
+		return a.dataDir
+	}
+	return filepath.Join(lib.g.GetHomeDir(), ".local", "share", "webkitgtk", a.name)
+}
+
+// Windows returns all open windows ordered by their id.
+func (a *App) Windows() []*Window {
+	a.windowsLock.RLock()
+	windows := make([]*Window, 0, len(a.windows))
+	for _, w := range a.windows {
+		windows = append(windows, w)
+	}
+	a.windowsLock.RUnlock()
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].id < windows[j].id
+	})
+	return windows
+}
+
+// Window returns the open window with the given name or nil if there is none.
+func (a *App) Window(name string) *Window {
+	a.windowsLock.RLock()
+	defer a.windowsLock.RUnlock()
+	for _, w := range a.windows {
+		if w.options.Name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+// EachWindow calls fn for every open window ordered by their id.
+func (a *App) EachWindow(fn func(*Window)) {
+	for _, w := range a.Windows() {
+		fn(w)
+	}
+}
+
+// OnSecondInstance registers a callback that is invoked in the running instance
+// whenever the app is launched again while in single instance mode. The callback
+// receives the command line arguments (without the program name) and the working
+// directory of the second instance.
+func (a *App) OnSecondInstance(callback func(args []string, cwd string)) {
+	a.onLock.Lock()
+	defer a.onLock.Unlock()
+	a.onSecondInstance = callback
+}
+
+// OnOpenURL registers a callback that is invoked when the app is launched with a URL
+// of one of the schemes in AppOptions.URLSchemes. URLs of later launches are forwarded
+// to the running instance.
+func (a *App) OnOpenURL(callback func(url string)) {
+	a.onLock.Lock()
+	defer a.onLock.Unlock()
+	a.onOpenURL = callback
+}
+
 func (a *App) CurrentWindow() *Window {
 	if a.pointer == 0 {
 		return nil
 	}
-	active := lib.gtk.ApplicationGetActiveWindow(a.pointer)
+	var active ptr
+	a.thread.InvokeSync(func() {
+		active = lib.gtk.ApplicationGetActiveWindow(a.pointer)
+	})
 	if active == 0 {
 		return nil
 	}
 	a.windowsLock.RLock()
-	windows := a.windows
-	a.windowsLock.RUnlock()
-	for _, w := range windows {
+	defer a.windowsLock.RUnlock()
+	for _, w := range a.windows {
 		if w.pointer == windowPtr(active) {
 			return w
 		}
@@ -129,93 +346,316 @@ func (a *App) CurrentWindow() *Window {
 	return nil
 }
 
-func (a *App) Run() (err error) {
+// RunErrorKind describes why running the application failed.
+type RunErrorKind int
+
+const (
+	// RunStartupFailed indicates the application could not be started.
+	RunStartupFailed RunErrorKind = iota + 1
+	// RunCanceled indicates the application was stopped because its context was canceled.
+	RunCanceled
+	// RunExitStatus indicates the application exited with a non-zero exit status.
+	RunExitStatus
+)
+
+// RunError is returned by App.Run and App.RunContext.
+type RunError struct {
+	Kind   RunErrorKind // Kind is the reason the application stopped
+	Status int          // Status is the exit status of the GTK application
+	Err    error        // Err is the underlying error
+}
+
+func (e *RunError) Error() string {
+	switch e.Kind {
+	case RunStartupFailed:
+		return "startup failed: " + e.Err.Error()
+	case RunCanceled:
+		return "canceled: " + e.Err.Error()
+	default:
+		return fmt.Sprintf("exit code: %d", e.Status)
+	}
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// Run runs the application until it quits.
+func (a *App) Run() error {
+	return a.RunContext(context.Background())
+}
+
+// RunContext runs the application until it quits or the given context is canceled.
+// On cancellation the main loop is stopped, open dialogs are dismissed and in-flight
+// API calls are awaited for at most AppOptions.ShutdownTimeout.
+func (a *App) RunContext(ctx context.Context) (err error) {
 	defer panicHandlerRecover()
 
 	// >>> STARTUP
 	startupTime := time.Now()
-	a.log("application startup...", "identifier", a.id, "pid", a.pid)
+	a.log.Info("application startup...", "identifier", a.id, "pid", a.pid)
 
 	// 1. Fix console spam (USR1)
 	if err := os.Setenv("JSC_SIGNAL_FOR_GC", "20"); err != nil {
-		return fmt.Errorf("failed to set JSC_SIGNAL_FOR_GC: %w", err)
+		return &RunError{Kind: RunStartupFailed, Err: fmt.Errorf("failed to set JSC_SIGNAL_FOR_GC: %w", err)}
 	}
 
 	// 2. Load shared libraries
 	if err := a.loadSharedLibs(); err != nil {
-		return fmt.Errorf("failed to load shared libraries: %w", err)
+		return &RunError{Kind: RunStartupFailed, Err: fmt.Errorf("failed to load shared libraries: %w", err)}
 	}
 
 	// 3. Validate application identifier
 	if !lib.g.ApplicationIdIsValid(a.id) {
-		return fmt.Errorf("invalid application identifier: %s", a.id)
+		return &RunError{Kind: RunStartupFailed, Err: fmt.Errorf("invalid application identifier: %s", a.id)}
+	}
+
+	// 3.1. Install desktop entry on first run
+	if a.desktop != nil && !a.desktopInstalled() {
+		if err := a.InstallDesktopEntry(*a.desktop); err != nil {
+			a.log.Warn("failed to install desktop entry", "error", err)
+		}
 	}
 
 	// 4. Get Main Thread and create GTK Application
-	a.thread = newMainThread()
-	a.pointer = lib.gtk.ApplicationNew(a.id, uint(0))
-	a.log("application created", "pointer", a.pointer, "thread", a.thread.ID())
+	flags := uint(gApplicationNonUnique)
+	if a.single {
+		flags = gApplicationHandlesCommandLine
+	}
+	a.thread = newMainThread(a.logger.With("component", "main-thread"))
+	a.started.prepare()
+	a.ctx, a.cancel = context.WithCancel(ctx)
+	a.pointer = lib.gtk.ApplicationNew(a.id, flags)
+	a.log.Info("application created", "pointer", a.pointer, "thread", a.thread.ID(), "single_instance", a.single)
+
+	// 4.1. Forward the command line if another instance is running already
+	var argv []*byte
+	if a.single {
+		argv = cStrings(os.Args)
+		if !lib.g.ApplicationRegister(a.pointer, 0, nil) {
+			a.releaseRemote()
+			return &RunError{Kind: RunStartupFailed, Err: fmt.Errorf("failed to register application %s", a.id)}
+		}
+		if lib.g.ApplicationGetIsRemote(a.pointer) {
+			status := lib.g.ApplicationRun(a.pointer, len(argv)-1, &argv[0]) // forwards the arguments
+			runtime.KeepAlive(argv)
+			a.log.Info("arguments forwarded to running instance", "status", status)
+			a.releaseRemote()
+			if status != 0 {
+				return &RunError{Kind: RunExitStatus, Status: status, Err: fmt.Errorf("exit code: %d", status)}
+			}
+			return nil
+		}
+	}
 
 	// 5. Establish DBUS session
 	var dbusPlugins []dbusPlugin
 	if a.trayMenu != nil {
-		a.systray = a.trayMenu.toTray(a.id, a.trayIcon)
+		a.systray = a.trayMenu.toTray(a.id, a.trayIcon, a.thread, a.logger.With("component", "dbus-systray"))
 		dbusPlugins = append(dbusPlugins, a.systray)
 	}
 	a.notifier = &dbusNotify{
+		log:     a.logger.With("component", "dbus-notify"),
 		appName: a.id,
 	}
 	dbusPlugins = append(dbusPlugins, a.notifier)
-	a.session, err = newDBusSession(dbusPlugins)
+	a.session, err = newDBusSession(a.logger.With("component", "dbus"), dbusPlugins)
 	if err != nil {
-		return fmt.Errorf("failed to create dbus session: %w", err)
+		return &RunError{Kind: RunStartupFailed, Err: fmt.Errorf("failed to create dbus session: %w", err)}
 	}
 
 	// 5. Setup activate signal ipc
-	lib.g.SignalConnectData(
+	signalConnect(
 		a.pointer,
 		"activate",
-		purego.NewCallback(func() {
+		callbackFunc1(),
+		func(ptr) {
 
 			// 7. Allow running without a window
 			lib.g.ApplicationHold(a.pointer)
 
-			// 8. Invoke deferred runners
+			// 8. Invoke startup hooks
+			a.hooks.invokeStartup()
+
+			// 9. Invoke deferred runners
 			a.started.invoke()
 
 			// <<< STARTUP
-			a.log("application startup complete", "since_startup", time.Since(startupTime))
-		}),
+			a.log.Info("application startup complete", "since_startup", time.Since(startupTime))
+		})
+
+	// 5.1. Save the window states before GTK destroys the windows, this covers all ways of
+	// quitting including App.Quit, the tray menu and the cancellation of the context
+	signalConnect(
 		a.pointer,
-		false,
-		0)
+		"shutdown",
+		callbackFunc1(),
+		func(ptr) {
+			a.windowsLock.RLock()
+			defer a.windowsLock.RUnlock()
+			for _, w := range a.windows {
+				// skip windows that were never created and hidden windows kept by
+				// HideOnClose, their state was saved when they were hidden
+				if w.pointer == 0 || !w.isVisible() {
+					continue
+				}
+				w.saveState()
+			}
+		})
+
+	// 6. Setup command-line signal to forward arguments of a second instance
+	if a.single {
+		signalConnect(
+			a.pointer,
+			"command-line",
+			callbackFunc2Int(),
+			func(app ptr, cmdline ptr) int {
+				var argc int
+				cargs := lib.g.ApplicationCommandLineGetArguments(cmdline, &argc)
+				args := goStrings(cargs, argc)
+				lib.g.Strfreev(cargs)
+				if len(args) > 0 {
+					args = args[1:]
+				}
+				if !lib.g.ApplicationCommandLineGetIsRemote(cmdline) {
+					lib.g.ApplicationActivate(a.pointer)
+					a.openURLs(args)
+					return 0
+				}
+				cwd := lib.g.ApplicationCommandLineGetCwd(cmdline)
+				a.log.Info("second instance launched", "args", args, "cwd", cwd)
+				a.secondInstance(args, cwd)
+				a.openURLs(args)
+				return 0
+			})
+	}
 
-	// 6. Run GTK Application
-	status := lib.g.ApplicationRun(a.pointer, 0, nil) // BLOCKING
+	// 7. Stop the application once the context is canceled
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.log.Info("context canceled, quitting", "error", ctx.Err())
+			a.thread.InvokeAsync(func() {
+				a.closeDialogs()
+				lib.g.ApplicationQuit(a.pointer)
+			})
+		case <-stopped:
+		}
+	}()
+
+	// 8. Run GTK Application
+	var status int
+	if len(argv) > 0 {
+		status = lib.g.ApplicationRun(a.pointer, len(argv)-1, &argv[0]) // BLOCKING
+	} else {
+		status = lib.g.ApplicationRun(a.pointer, 0, nil) // BLOCKING
+	}
+	runtime.KeepAlive(argv)
+	close(stopped)
 
 	// >>> SHUTDOWN
 	shutdownTime := time.Now()
-	a.log("application shutdown...", "status", status)
+	a.log.Info("application shutdown...", "status", status)
+
+	// 1. Invoke shutdown hooks and cancel running api calls
+	a.hooks.invokeShutdown()
+	a.cancel()
 
-	// 1. Close dbus session
+	// 2. Stop timers and dispatching to the main thread
+	a.stopTimers()
+	a.thread.stop()
+
+	// 3. Wait for in-flight api calls and dialogs
+	if !a.inflight.wait(a.shutdownTimeout) {
+		a.log.Warn("timeout waiting for in-flight calls", "timeout", a.shutdownTimeout, "pending", a.inflight.count())
+	}
+
+	// 4. Close dbus session
 	a.session.close()
 
-	// 2. Release GTK Application and dereference application pointer
+	// 5. Release GTK Application and dereference application pointer
 	lib.g.ApplicationRelease(a.pointer)
 	lib.g.ObjectUnref(a.pointer)
+	a.pointer = 0
 
-	// 3. Handle exit status
-	if status == 0 {
-		err = nil
-	} else {
-		err = fmt.Errorf("exit code: %d", status)
+	// 6. Release web context and reset state so the app can be run again
+	if a.webContext != 0 {
+		lib.g.ObjectUnref(a.webContext)
+		a.webContext = 0
+	}
+	a.windowsLock.Lock()
+	a.windows = make(map[uint]*Window)
+	a.windowsLock.Unlock()
+	a.dialogsLock.Lock()
+	a.dialogs = make(map[uint64]interface{})
+	a.dialogsLock.Unlock()
+	a.blobs.clear()
+	a.started.reset()
+
+	// 7. Handle exit status
+	if ctx.Err() != nil {
+		err = &RunError{Kind: RunCanceled, Status: status, Err: ctx.Err()}
+	} else if status != 0 {
+		err = &RunError{Kind: RunExitStatus, Status: status, Err: fmt.Errorf("exit code: %d", status)}
 	}
 
 	// <<< SHUTDOWN
-	a.log("application shutdown done", "error", err, "since_shutdown", time.Since(shutdownTime))
+	a.log.Info("application shutdown done", "error", err, "since_shutdown", time.Since(shutdownTime))
 	return err
 }
 
+// releaseRemote releases the application that was not started, since it forwarded its
+// arguments to the running instance or could not be registered.
+func (a *App) releaseRemote() {
+	a.cancel()
+	a.thread.stop()
+	lib.g.ObjectUnref(a.pointer)
+	a.pointer = 0
+}
+
+// secondInstance focuses the current window and invokes the second instance callback.
+func (a *App) secondInstance(args []string, cwd string) {
+	if w := a.CurrentWindow(); w != nil {
+		w.Focus()
+	} else {
+		a.windowsLock.RLock()
+		for _, w := range a.windows {
+			w.Focus()
+			break
+		}
+		a.windowsLock.RUnlock()
+	}
+	a.onLock.RLock()
+	onSecondInstance := a.onSecondInstance
+	a.onLock.RUnlock()
+	if onSecondInstance != nil {
+		go onSecondInstance(args, cwd)
+	}
+}
+
+// openURLs invokes the open URL callback for all arguments matching one of the app schemes.
+func (a *App) openURLs(args []string) {
+	for _, arg := range args {
+		u, err := url.Parse(arg)
+		if err != nil || u.Scheme == "" {
+			continue
+		}
+		for _, scheme := range a.schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				a.log.Info("open url", "url", arg)
+				a.onLock.RLock()
+				onOpenURL := a.onOpenURL
+				a.onLock.RUnlock()
+				if onOpenURL != nil {
+					go onOpenURL(arg)
+				}
+				break
+			}
+		}
+	}
+}
+
 func (a *App) Quit() {
 	a.thread.InvokeSync(func() {
 		lib.g.ApplicationQuit(a.pointer)
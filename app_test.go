@@ -0,0 +1,67 @@
+package webkitgtk
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+func TestAppAccessorsConcurrent(t *testing.T) {
+	l := newTestLoop(t)
+
+	var active ptr // only accessed on the main thread
+	stubLib(t, &lib.gtk.ApplicationGetActiveWindow, func(ptr) ptr {
+		l.onMainThread(t, "gtk_application_get_active_window")
+		return active
+	})
+
+	a := &App{
+		log:     slog.New(discardHandler{}),
+		pointer: 1,
+		thread:  l.mt,
+		windows: make(map[uint]*Window),
+		schemes: []string{"test"},
+	}
+	a.started.invoke()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				w := &Window{app: a, id: uint(g*100 + i + 1), pointer: windowPtr(g*100 + i + 1)}
+				a.windowsLock.Lock()
+				a.windows[w.id] = w
+				a.windowsLock.Unlock()
+
+				err := a.DispatchSync(context.Background(), func() {
+					l.onMainThread(t, "DispatchSync")
+					active = ptr(w.pointer)
+				})
+				if err != nil {
+					t.Errorf("DispatchSync: %v", err)
+				}
+				a.CurrentWindow()
+				a.Windows()
+				a.Window("window")
+				a.EachWindow(func(*Window) {})
+				a.OnOpenURL(func(string) {})
+				a.OnSecondInstance(func([]string, string) {})
+				a.openURLs([]string{"test://open"})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := len(a.Windows()); n != 800 {
+		t.Fatalf("%d windows, want 800", n)
+	}
+	windows := a.Windows()
+	for i := 1; i < len(windows); i++ {
+		if windows[i-1].id >= windows[i].id {
+			t.Fatalf("windows not ordered by id")
+		}
+	}
+}
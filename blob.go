@@ -0,0 +1,231 @@
+package webkitgtk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiBlobHost is the host of the app:// endpoint binary api arguments and results are
+// transferred through, so they bypass the JSON encoding and the script evaluation.
+const apiBlobHost = "webkit-api"
+
+// apiBlobTTL is the time after which blobs that were never taken are discarded.
+const apiBlobTTL = time.Minute
+
+// apiBlobMaxUpload is the maximum size of a blob uploaded as api argument.
+const apiBlobMaxUpload = 256 << 20
+
+var apiErrBlobNotFound = errors.New("blob not found")
+
+var (
+	apiBytesType  = reflect.TypeOf([]byte(nil))
+	apiReaderType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+)
+
+// apiBlob is a binary payload waiting to be fetched by JavaScript or to be passed to a call.
+type apiBlob struct {
+	data   []byte
+	reader io.Reader   // reader is streamed instead of data if set
+	timer  *time.Timer // timer discards the blob once apiBlobTTL passed
+}
+
+func (b *apiBlob) close() {
+	if closer, ok := b.reader.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// apiBlobs stores the blobs of all windows by their random token. Each blob can be taken
+// once, either by an api call it was uploaded for or by the download of a result.
+type apiBlobs struct {
+	mutex sync.Mutex
+	blobs map[string]*apiBlob
+}
+
+func (s *apiBlobs) put(blob *apiBlob) string {
+	token := apiToken()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.blobs == nil {
+		s.blobs = make(map[string]*apiBlob)
+	}
+	s.blobs[token] = blob
+	blob.timer = time.AfterFunc(apiBlobTTL, func() {
+		s.expire(token, blob)
+	})
+	return token
+}
+
+func (s *apiBlobs) take(token string) (*apiBlob, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	blob, ok := s.blobs[token]
+	if ok {
+		blob.timer.Stop()
+		delete(s.blobs, token)
+	}
+	return blob, ok
+}
+
+// expire discards the blob if it was not taken in time, e.g. because the page went away
+// before it fetched a result or the call an argument was uploaded for never ran.
+func (s *apiBlobs) expire(token string, blob *apiBlob) {
+	s.mutex.Lock()
+	if s.blobs[token] != blob {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.blobs, token)
+	s.mutex.Unlock()
+	blob.close()
+}
+
+// clear discards all blobs, e.g. when the app shuts down.
+func (s *apiBlobs) clear() {
+	s.mutex.Lock()
+	blobs := s.blobs
+	s.blobs = nil
+	s.mutex.Unlock()
+	for _, blob := range blobs {
+		blob.timer.Stop()
+		blob.close()
+	}
+}
+
+// decode sets the []byte or io.Reader argument from the blob referenced by raw, which is
+// sent by the JS client as {"$blob": token} for ArrayBuffer, typed array and Blob arguments.
+// It reports false if raw is no blob reference.
+func (s *apiBlobs) decode(raw json.RawMessage, input reflect.Value) (bool, error) {
+	if len(raw) == 0 || raw[0] != '{' {
+		return false, nil
+	}
+	var ref struct {
+		Blob *string `json:"$blob"`
+	}
+	if err := json.Unmarshal(raw, &ref); err != nil || ref.Blob == nil {
+		return false, nil
+	}
+	blob, ok := s.take(*ref.Blob)
+	if !ok {
+		return true, apiErrBlobNotFound
+	}
+	if input.Type() == apiBytesType {
+		input.SetBytes(blob.data)
+	} else {
+		input.Set(reflect.ValueOf(bytes.NewReader(blob.data)))
+	}
+	return true, nil
+}
+
+// encode stores a []byte or io.Reader result and returns the message resolving the call
+// with an ArrayBuffer or a Blob once fetched. It reports false for other results.
+func (s *apiBlobs) encode(output reflect.Value) (apiMessage, bool) {
+	switch {
+	case output.Type() == apiBytesType:
+		return apiMessage{Type: "blob", Token: s.put(&apiBlob{data: output.Bytes()})}, true
+	case output.Type().Implements(apiReaderType):
+		if (output.Kind() == reflect.Interface || output.Kind() == reflect.Ptr) && output.IsNil() {
+			return apiMessage{Type: "resolve", Result: json.RawMessage("null")}, true
+		}
+		return apiMessage{Type: "blob", Token: s.put(&apiBlob{reader: output.Interface().(io.Reader)}), Blob: true}, true
+	}
+	return apiMessage{}, false
+}
+
+// apiBlobResponseWriter is the response writer of the blob endpoint, which is closed
+// once the response is complete.
+type apiBlobResponseWriter interface {
+	http.ResponseWriter
+	io.Closer
+}
+
+// blobOrigins returns the allowed origins of the window showing the webview. It returns
+// nil if the webview belongs to no window or its page may not use the api. Must be called
+// on the main thread.
+func (a *App) blobOrigins(webview webviewPtr) []string {
+	if webview == 0 {
+		return nil
+	}
+	a.windowsLock.RLock()
+	defer a.windowsLock.RUnlock()
+	for _, w := range a.windows {
+		if w.webview != webview {
+			continue
+		}
+		if !apiOriginAllowed(w.options.AllowedOrigins, apiOrigin(lib.webkit.WebViewGetUri(webview))) {
+			return nil
+		}
+		return w.options.AllowedOrigins
+	}
+	return nil
+}
+
+// serve handles requests to the blob endpoint. A POST to /upload stores the body and
+// responds with its token, a GET to /<token> takes the blob and streams it. Requests are
+// only served to the allowed origins of the window sending them, which the CORS headers
+// are restricted to. serve takes ownership of the response writer, since the body is
+// written in the background to not block the main loop reading the other end of the pipe.
+func (s *apiBlobs) serve(rw apiBlobResponseWriter, req *http.Request, allowed []string) {
+	origin := req.Header.Get("Origin")
+	if len(allowed) == 0 || (origin != "" && !apiOriginAllowed(allowed, origin)) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		rw.Close()
+		return
+	}
+	if origin != "" {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		rw.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		rw.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		rw.Header().Set("Vary", "Origin")
+	}
+	switch {
+	case req.Method == http.MethodOptions:
+		rw.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPost && req.URL.Path == "/upload":
+		data, err := io.ReadAll(http.MaxBytesReader(rw, req.Body, apiBlobMaxUpload))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+				break
+			}
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			break
+		}
+		rw.Header().Set("Content-Type", "text/plain")
+		io.WriteString(rw, s.put(&apiBlob{data: data}))
+	case req.Method == http.MethodGet:
+		blob, ok := s.take(strings.TrimPrefix(req.URL.Path, "/"))
+		if !ok {
+			http.Error(rw, apiErrBlobNotFound.Error(), http.StatusNotFound)
+			break
+		}
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		if blob.reader == nil {
+			rw.Header().Set("Content-Length", strconv.Itoa(len(blob.data)))
+		}
+		rw.WriteHeader(http.StatusOK)
+		go func() {
+			defer rw.Close()
+			defer blob.close()
+			if blob.reader != nil {
+				io.Copy(rw, blob.reader)
+			} else {
+				rw.Write(blob.data)
+			}
+		}()
+		return
+	default:
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	rw.Close()
+}
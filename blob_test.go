@@ -0,0 +1,160 @@
+package webkitgtk
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// blobRecorder records the response of the blob endpoint until it is closed.
+type blobRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan struct{}
+}
+
+func newBlobRecorder() *blobRecorder {
+	return &blobRecorder{ResponseRecorder: httptest.NewRecorder(), closed: make(chan struct{})}
+}
+
+func (r *blobRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func serveBlob(s *apiBlobs, req *http.Request, allowed []string) *httptest.ResponseRecorder {
+	rw := newBlobRecorder()
+	s.serve(rw, req, allowed)
+	<-rw.closed
+	return rw.ResponseRecorder
+}
+
+func TestBlobServeOrigin(t *testing.T) {
+	allowed := []string{"app://"}
+	tests := []struct {
+		name    string
+		origin  string
+		allowed []string
+		status  int
+	}{
+		{"allowed origin", "app://main", allowed, http.StatusOK},
+		{"no origin header", "", allowed, http.StatusOK},
+		{"third-party origin", "https://example.com", allowed, http.StatusForbidden},
+		{"page not allowed", "app://main", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s apiBlobs
+			defer s.clear()
+			req := httptest.NewRequest(http.MethodPost, "app://webkit-api/upload", strings.NewReader("data"))
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			res := serveBlob(&s, req, tt.allowed)
+			if res.Code != tt.status {
+				t.Fatalf("status %d, want %d", res.Code, tt.status)
+			}
+			if got := res.Header().Get("Access-Control-Allow-Origin"); got == "*" || (got != "" && got != tt.origin) {
+				t.Fatalf("Access-Control-Allow-Origin is %q for origin %q", got, tt.origin)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			token := res.Body.String()
+			get := httptest.NewRequest(http.MethodGet, "app://webkit-api/"+token, nil)
+			get.Header.Set("Origin", tt.origin)
+			if res := serveBlob(&s, get, tt.allowed); res.Code != http.StatusOK || res.Body.String() != "data" {
+				t.Fatalf("fetch returned %d %q", res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestBlobServeUploadLimit(t *testing.T) {
+	var s apiBlobs
+	defer s.clear()
+	req := httptest.NewRequest(http.MethodPost, "app://webkit-api/upload", bytes.NewReader(make([]byte, apiBlobMaxUpload+1)))
+	if res := serveBlob(&s, req, []string{"app://"}); res.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", res.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(s.blobs) != 0 {
+		t.Fatal("blob stored although it exceeds the limit")
+	}
+}
+
+func TestBlobExpire(t *testing.T) {
+	var s apiBlobs
+	defer s.clear()
+	blob := &apiBlob{data: []byte("data")}
+	token := s.put(blob)
+	if blob.timer == nil {
+		t.Fatal("no expiry timer started")
+	}
+
+	// expiring a blob that was taken already is a no-op
+	other := &apiBlob{data: []byte("other")}
+	otherToken := s.put(other)
+	if _, ok := s.take(otherToken); !ok {
+		t.Fatal("blob not found")
+	}
+	s.expire(otherToken, other)
+
+	s.expire(token, blob)
+	if _, ok := s.take(token); ok {
+		t.Fatal("expired blob was taken")
+	}
+	if len(s.blobs) != 0 {
+		t.Fatalf("%d blobs left", len(s.blobs))
+	}
+}
+
+func BenchmarkBlobTransfer(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20, 16 << 20} {
+		data := bytes.Repeat([]byte{0xAB}, size)
+		b.Run("base64/"+byteSize(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				// the result is encoded into the message and decoded again by the client
+				msg, err := json.Marshal(apiMessage{Type: "resolve", Result: mustMarshal(b, data)})
+				if err != nil {
+					b.Fatal(err)
+				}
+				var reply struct{ Result []byte }
+				if err := json.Unmarshal(msg, &reply); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("blob/"+byteSize(size), func(b *testing.B) {
+			var s apiBlobs
+			defer s.clear()
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				msg, _ := s.encode(reflect.ValueOf(data))
+				res := serveBlob(&s, httptest.NewRequest(http.MethodGet, "app://webkit-api/"+msg.Token, nil), []string{"app://"})
+				if res.Body.Len() != size {
+					b.Fatalf("fetched %d bytes, want %d", res.Body.Len(), size)
+				}
+			}
+		})
+	}
+}
+
+func mustMarshal(tb testing.TB, v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
+
+func byteSize(size int) string {
+	if size >= 1<<20 {
+		return strconv.Itoa(size>>20) + "MiB"
+	}
+	return strconv.Itoa(size>>10) + "KiB"
+}
@@ -0,0 +1,73 @@
+package webkitgtk
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Call is a call from JavaScript to a bound function as seen by the CallHandler chain.
+type Call struct {
+	ID     uint64          // ID is the id of the call, unique per page load
+	Window *Window         // Window is the window the call was sent from
+	Origin string          // Origin is the origin of the page, e.g. app:// or https://example.com
+	API    string          // API is the name of the binding in WindowOptions.Define
+	Method string          // Method is the function name, dotted for nested namespaces and empty for funcs
+	Args   json.RawMessage // Args is the JSON array of arguments, a middleware may replace it
+
+	method *apiMethod
+	blobs  *apiBlobs
+}
+
+// CallHandler handles a call from JavaScript. The result is sent to JavaScript, an error
+// rejects the call. The context is canceled if the caller aborts or the page goes away.
+type CallHandler func(ctx context.Context, call *Call) (any, error)
+
+// apiInvoke is the innermost CallHandler, it decodes the arguments and calls the function.
+func apiInvoke(ctx context.Context, call *Call) (any, error) {
+	output, err := call.method.call(ctx, string(call.Args), call.blobs)
+	if err != nil || !output.IsValid() {
+		return nil, err
+	}
+	return output.Interface(), nil
+}
+
+// apiChain wraps the handler with the middleware, the first middleware is the outermost.
+func apiChain(handler CallHandler, middleware ...[]func(next CallHandler) CallHandler) CallHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		for j := len(middleware[i]) - 1; j >= 0; j-- {
+			handler = middleware[i][j](handler)
+		}
+	}
+	return handler
+}
+
+// apiOrigin returns the origin of the page URI, "null" for opaque URIs like about:blank.
+func apiOrigin(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return ""
+	}
+	if u.Opaque != "" {
+		return "null"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// apiOriginAllowed reports if the origin matches one of the allowed origins. An allowed
+// origin without host like app:// matches all hosts of the scheme, "*" matches any origin.
+func apiOriginAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if !strings.HasSuffix(a, "://") {
+			a = strings.TrimSuffix(a, "/")
+		}
+		switch {
+		case a == "*", a == origin:
+			return true
+		case strings.HasSuffix(a, "://") && strings.HasPrefix(origin, a):
+			return true
+		}
+	}
+	return false
+}
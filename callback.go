@@ -0,0 +1,106 @@
+package webkitgtk
+
+import (
+	"github.com/ebitengine/purego"
+	"sync"
+)
+
+// callbacks maps the ids passed as user data to the static trampolines to Go functions.
+// purego callbacks can never be freed and their number is limited, so all C callbacks
+// go through a small set of trampolines that are created once.
+var callbacks = &callbackRegistry{fns: make(map[ptr]any)}
+
+type callbackRegistry struct {
+	mutex sync.RWMutex
+	id    ptr
+	fns   map[ptr]any
+}
+
+// register stores fn and returns the id to pass as user data.
+func (r *callbackRegistry) register(fn any) ptr {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for {
+		r.id++
+		if _, exists := r.fns[r.id]; r.id != 0 && !exists {
+			r.fns[r.id] = fn
+			return r.id
+		}
+	}
+}
+
+// registerOnce stores fn and removes it again after it was called once.
+func (r *callbackRegistry) registerOnce(fn func(ptr, ptr)) ptr {
+	var id ptr
+	id = r.register(func(a ptr, b ptr) {
+		r.unregister(id)
+		fn(a, b)
+	})
+	return id
+}
+
+func (r *callbackRegistry) unregister(id ptr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.fns, id)
+}
+
+func (r *callbackRegistry) get(id ptr) any {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.fns[id]
+}
+
+// callbackFunc1 is the trampoline for callbacks with one argument, e.g. GCallback
+// handlers of the activate signal.
+var callbackFunc1 = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(func(a ptr, data ptr) {
+		if fn, ok := callbacks.get(data).(func(ptr)); ok {
+			fn(a)
+		}
+	}))
+})
+
+// callbackFunc2 is the trampoline for callbacks with two arguments, e.g. GAsyncReadyCallback.
+var callbackFunc2 = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(func(a ptr, b ptr, data ptr) {
+		if fn, ok := callbacks.get(data).(func(ptr, ptr)); ok {
+			fn(a, b)
+		}
+	}))
+})
+
+// callbackFunc2Int is the trampoline for callbacks with two arguments returning an int,
+// e.g. event handlers returning a gboolean.
+var callbackFunc2Int = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(func(a ptr, b ptr, data ptr) int {
+		if fn, ok := callbacks.get(data).(func(ptr, ptr) int); ok {
+			return fn(a, b)
+		}
+		return 0
+	}))
+})
+
+// callbackSource is the trampoline for GSourceFunc callbacks of timeout and idle sources.
+var callbackSource = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(func(data ptr) int {
+		if fn, ok := callbacks.get(data).(func() int); ok {
+			return fn()
+		}
+		return gSourceRemove
+	}))
+})
+
+// callbackDestroy is the trampoline releasing the function once GLib drops the user data.
+// It is used as GDestroyNotify and GClosureNotify, which both pass the data first.
+var callbackDestroy = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(func(data ptr) {
+		callbacks.unregister(data)
+	}))
+})
+
+// signalConnect connects fn to the signal of the instance using the given trampoline.
+// The function is released once the instance is finalized.
+func signalConnect(instance ptr, signal string, trampoline ptr, fn any) uint {
+	return lib.g.SignalConnectData(instance, signal, trampoline, callbacks.register(fn), callbackDestroy(), 0)
+}
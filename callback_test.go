@@ -0,0 +1,103 @@
+package webkitgtk
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCallbackRegistryRegister(t *testing.T) {
+	r := &callbackRegistry{fns: make(map[ptr]any)}
+
+	ids := make(map[ptr]bool)
+	for i := 0; i < 100; i++ {
+		i := i
+		id := r.register(func() int { return i })
+		if id == 0 || ids[id] {
+			t.Fatalf("register returned id %d twice or zero", id)
+		}
+		ids[id] = true
+	}
+	for id := range ids {
+		fn, ok := r.get(id).(func() int)
+		if !ok {
+			t.Fatalf("no function registered for id %d", id)
+		}
+		if got := ptr(fn()) + 1; got != id {
+			t.Fatalf("id %d returned function %d", id, got)
+		}
+	}
+}
+
+func TestCallbackRegistryRegisterWraparound(t *testing.T) {
+	r := &callbackRegistry{fns: make(map[ptr]any)}
+	r.id = ^ptr(0) - 1
+
+	// ids in use and zero, which is no valid user data, are skipped
+	r.fns[1] = "used"
+	first := r.register("a")
+	second := r.register("b")
+	if first != ^ptr(0) || second != 2 {
+		t.Fatalf("got ids %d and %d, want %d and 2", first, second, ^ptr(0))
+	}
+	if r.get(1) != "used" {
+		t.Fatal("registered function was replaced")
+	}
+}
+
+func TestCallbackRegistryUnregister(t *testing.T) {
+	r := &callbackRegistry{fns: make(map[ptr]any)}
+	id := r.register(func(ptr) {})
+	r.unregister(id)
+	if r.get(id) != nil {
+		t.Fatal("function still registered after unregister")
+	}
+	if len(r.fns) != 0 {
+		t.Fatalf("%d functions left after unregister", len(r.fns))
+	}
+	r.unregister(id) // unregistering twice, e.g. by a destroy notify, is a no-op
+}
+
+func TestCallbackRegistryRegisterOnce(t *testing.T) {
+	r := &callbackRegistry{fns: make(map[ptr]any)}
+	var calls int
+	var got [2]ptr
+	id := r.registerOnce(func(a ptr, b ptr) {
+		calls++
+		got = [2]ptr{a, b}
+	})
+
+	fn, ok := r.get(id).(func(ptr, ptr))
+	if !ok {
+		t.Fatalf("registerOnce stored %T", r.get(id))
+	}
+	fn(1, 2)
+	if calls != 1 || got != [2]ptr{1, 2} {
+		t.Fatalf("callback called %d times with %v", calls, got)
+	}
+	if r.get(id) != nil || len(r.fns) != 0 {
+		t.Fatal("function still registered after it was called")
+	}
+}
+
+func TestCallbackRegistryConcurrent(t *testing.T) {
+	r := &callbackRegistry{fns: make(map[ptr]any)}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := r.registerOnce(func(ptr, ptr) {})
+				if fn, ok := r.get(id).(func(ptr, ptr)); ok {
+					fn(0, 0)
+				} else {
+					t.Errorf("no function registered for id %d", id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(r.fns) != 0 {
+		t.Fatalf("%d functions left", len(r.fns))
+	}
+}
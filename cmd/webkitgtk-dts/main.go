@@ -0,0 +1,100 @@
+// Command webkitgtk-dts writes TypeScript declarations for the WindowOptions.Define map
+// returned by an exported function of a Go package. It is meant to be used with go generate:
+//
+//	//go:generate go run github.com/malivvan/webkitgtk/cmd/webkitgtk-dts -func Define -o api.d.ts
+//
+// The function must have the signature func() map[string]interface{}. Since package main
+// can not be imported, the function has to live in a regular package.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var generator = template.Must(template.New("main.go").Parse(`package main
+
+import (
+	"fmt"
+	"os"
+
+	ui "github.com/malivvan/webkitgtk"
+	pkg {{printf "%q" .Import}}
+)
+
+func main() {
+	if err := ui.WriteTypeScript({{printf "%q" .Output}}, pkg.{{.Func}}()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`))
+
+func main() {
+	pkg := flag.String("pkg", ".", "package containing the function")
+	fn := flag.String("func", "Define", "function returning the define map")
+	out := flag.String("o", "api.d.ts", "output file")
+	flag.Parse()
+
+	if err := run(*pkg, *fn, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "webkitgtk-dts:", err)
+		os.Exit(1)
+	}
+}
+
+func run(pkg string, fn string, out string) error {
+	// 1. Resolve the package
+	list, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}} {{.Dir}}", pkg).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("go list: %s", bytes.TrimSpace(exitErr.Stderr))
+		}
+		return fmt.Errorf("go list: %w", err)
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(list)), " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("go list: unexpected output %q", list)
+	}
+	importPath, name, dir := fields[0], fields[1], fields[2]
+	if name == "main" {
+		return fmt.Errorf("package %s is a main package and can not be imported", importPath)
+	}
+	output, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+
+	// 2. Write a temporary program calling the function inside the module of the package
+	tmp, err := os.MkdirTemp(dir, ".webkitgtk-dts-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	var src bytes.Buffer
+	if err := generator.Execute(&src, struct {
+		Import string
+		Func   string
+		Output string
+	}{importPath, fn, output}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "main.go"), src.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	// 3. Run the program
+	cmd := exec.Command("go", "run", "./"+filepath.Base(tmp))
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("generator failed: %w", err)
+	}
+	return nil
+}
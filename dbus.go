@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
+	"log/slog"
 	"sync"
 )
 
@@ -17,17 +18,15 @@ type dbusPlugin interface {
 
 type dbusSession struct {
 	wg      sync.WaitGroup
-	log     logFunc
+	log     *slog.Logger
 	quit    chan struct{}
 	plugins []dbusPlugin
 }
 
-func newDBusSession(plugins []dbusPlugin) (*dbusSession, error) {
-	s := &dbusSession{plugins: plugins}
+func newDBusSession(log *slog.Logger, plugins []dbusPlugin) (*dbusSession, error) {
+	s := &dbusSession{log: log, plugins: plugins}
 
-	s.log = newLogFunc("dbus")
-
-	s.log("starting dbus session routine")
+	s.log.Debug("starting dbus session routine")
 	conn, err := dbus.SessionBus()
 	if err != nil {
 		return nil, fmt.Errorf("dbusSystray error: failed to connect to DBus: %v\n", err)
@@ -40,10 +39,10 @@ func newDBusSession(plugins []dbusPlugin) (*dbusSession, error) {
 	}
 
 	s.wg.Add(1)
-	s.log("dbus session routine started")
+	s.log.Debug("dbus session routine started")
 	go func() {
 		defer func() {
-			s.log("dbus session routine stopped")
+			s.log.Debug("dbus session routine stopped")
 			s.wg.Done()
 		}()
 
@@ -53,7 +52,7 @@ func newDBusSession(plugins []dbusPlugin) (*dbusSession, error) {
 		for {
 			select {
 			case sig := <-sc:
-				s.log("dbus signal received", "signal", sig)
+				s.log.Debug("dbus signal received", "signal", sig)
 				if sig == nil {
 					return // We get a nil signal when closing the window.
 				}
@@ -61,7 +60,7 @@ func newDBusSession(plugins []dbusPlugin) (*dbusSession, error) {
 					plugin.Signal(sig)
 				}
 			case <-s.quit:
-				s.log("stopping dbus session routine")
+				s.log.Debug("stopping dbus session routine")
 				for _, plugin := range s.plugins {
 					plugin.Stop()
 				}
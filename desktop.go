@@ -0,0 +1,241 @@
+package webkitgtk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// desktopIconSizes are the sizes the application icon is installed at in the hicolor theme.
+var desktopIconSizes = []int{16, 24, 32, 48, 64, 128, 256}
+
+type DesktopEntry struct {
+
+	// Exec is the command line used to launch the app. Default: the current executable followed by %U
+	Exec string
+
+	// Comment is a short description of the app shown as tooltip.
+	Comment string
+
+	// Categories the app is listed under in menus, e.g. Utility, Development.
+	Categories []string
+
+	// MimeTypes the app is able to open.
+	MimeTypes []string
+
+	// Keywords used to find the app in launchers.
+	Keywords []string
+
+	// Terminal indicates if the app has to be run in a terminal.
+	Terminal bool
+}
+
+// InstallDesktopEntry writes the desktop entry of the app to $XDG_DATA_HOME/applications/<ID>.desktop
+// and installs the app icon in multiple sizes under the hicolor icon theme.
+func (a *App) InstallDesktopEntry(entry DesktopEntry) error {
+	dataHome, err := desktopDataHome()
+	if err != nil {
+		return err
+	}
+
+	// 1. Install icon in all sizes
+	img, err := png.Decode(bytes.NewReader(a.icon))
+	if err != nil {
+		return fmt.Errorf("failed to decode icon: %w", err)
+	}
+	for _, size := range desktopIconSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, imageResize(img, size, size)); err != nil {
+			return fmt.Errorf("failed to encode icon: %w", err)
+		}
+		if err := desktopWriteFile(desktopIconPath(dataHome, a.id, size), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	// 2. Write desktop file
+	if entry.Exec == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to determine executable: %w", err)
+		}
+		entry.Exec = desktopQuoteExec(executable) + " %U"
+	}
+	for _, scheme := range a.schemes {
+		entry.MimeTypes = append(entry.MimeTypes, "x-scheme-handler/"+strings.ToLower(scheme))
+	}
+	if err := desktopWriteFile(desktopFilePath(dataHome, a.id), []byte(a.desktopFile(entry))); err != nil {
+		return err
+	}
+	a.log.Info("desktop entry installed", "path", desktopFilePath(dataHome, a.id))
+
+	// 3. Refresh caches and register as default scheme handler (best effort)
+	desktopUpdateCaches(dataHome)
+	if path, err := exec.LookPath("xdg-mime"); err == nil {
+		for _, scheme := range a.schemes {
+			_ = exec.Command(path, "default", a.id+".desktop", "x-scheme-handler/"+strings.ToLower(scheme)).Run()
+		}
+	}
+	return nil
+}
+
+// UninstallDesktopEntry removes the desktop entry and icons installed by InstallDesktopEntry.
+func (a *App) UninstallDesktopEntry() error {
+	dataHome, err := desktopDataHome()
+	if err != nil {
+		return err
+	}
+	paths := []string{desktopFilePath(dataHome, a.id)}
+	for _, size := range desktopIconSizes {
+		paths = append(paths, desktopIconPath(dataHome, a.id, size))
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	desktopUpdateCaches(dataHome)
+	a.log.Info("desktop entry uninstalled", "path", desktopFilePath(dataHome, a.id))
+	return errors.Join(errs...)
+}
+
+// desktopInstalled reports if the desktop file of the app exists.
+func (a *App) desktopInstalled() bool {
+	dataHome, err := desktopDataHome()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(desktopFilePath(dataHome, a.id))
+	return err == nil
+}
+
+func (a *App) desktopFile(entry DesktopEntry) string {
+	var s strings.Builder
+	s.WriteString("[Desktop Entry]\n")
+	s.WriteString("Type=Application\n")
+	s.WriteString("Version=1.0\n")
+	s.WriteString("Name=" + desktopEscape(a.name) + "\n")
+	if entry.Comment != "" {
+		s.WriteString("Comment=" + desktopEscape(entry.Comment) + "\n")
+	}
+	s.WriteString("Exec=" + entry.Exec + "\n")
+	s.WriteString("Icon=" + a.id + "\n")
+	s.WriteString("Terminal=" + strconv.FormatBool(entry.Terminal) + "\n")
+	s.WriteString("StartupNotify=true\n")
+	if len(entry.Categories) > 0 {
+		s.WriteString("Categories=" + desktopList(entry.Categories) + "\n")
+	}
+	if len(entry.MimeTypes) > 0 {
+		s.WriteString("MimeType=" + desktopList(entry.MimeTypes) + "\n")
+	}
+	if len(entry.Keywords) > 0 {
+		s.WriteString("Keywords=" + desktopList(entry.Keywords) + "\n")
+	}
+	return s.String()
+}
+
+func desktopDataHome() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		return dataHome, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+func desktopFilePath(dataHome string, id string) string {
+	return filepath.Join(dataHome, "applications", id+".desktop")
+}
+
+func desktopIconPath(dataHome string, id string, size int) string {
+	return filepath.Join(dataHome, "icons", "hicolor", fmt.Sprintf("%dx%d", size, size), "apps", id+".png")
+}
+
+func desktopWriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func desktopUpdateCaches(dataHome string) {
+	if path, err := exec.LookPath("update-desktop-database"); err == nil {
+		_ = exec.Command(path, filepath.Join(dataHome, "applications")).Run()
+	}
+	if path, err := exec.LookPath("gtk-update-icon-cache"); err == nil {
+		_ = exec.Command(path, "-f", "-t", filepath.Join(dataHome, "icons", "hicolor")).Run()
+	}
+}
+
+// desktopEscape escapes a string value of a desktop file.
+func desktopEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\t", "\\t", "\r", "\\r").Replace(s)
+}
+
+// desktopList encodes a list of strings value of a desktop file.
+func desktopList(values []string) string {
+	var s strings.Builder
+	for _, value := range values {
+		s.WriteString(strings.ReplaceAll(desktopEscape(value), ";", "\\;"))
+		s.WriteString(";")
+	}
+	return s.String()
+}
+
+// desktopQuoteExec quotes an argument of the Exec key if required.
+func desktopQuoteExec(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`%") {
+		return arg
+	}
+	arg = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "`", "\\`", "$", "\\$").Replace(arg)
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	return desktopEscape("\"" + arg + "\"")
+}
+
+// imageResize scales the image to the given size by averaging the covered source pixels.
+func imageResize(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*sh/height
+		y1 := bounds.Min.Y + (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*sw/width
+			x1 := bounds.Min.X + (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((r / n) >> 8)
+			dst.Pix[i+1] = uint8((g / n) >> 8)
+			dst.Pix[i+2] = uint8((b / n) >> 8)
+			dst.Pix[i+3] = uint8((a / n) >> 8)
+		}
+	}
+	return dst
+}
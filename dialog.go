@@ -1,8 +1,8 @@
 package webkitgtk
 
 import (
+	"log/slog"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"unsafe"
 )
@@ -32,18 +32,18 @@ const (
 	GtkDialogUseHeaderBar      = 1 << 2 // actions in header bar instead of action area
 
 	GtkOrientationVertical = 1
-)
 
-var dialogMapID = make(map[uint64]struct{})
-var dialogIDLock sync.RWMutex
+	// https://gitlab.gnome.org/GNOME/gtk/-/blob/gtk-3-24/gtk/gtkdialog.h#L99
+	GtkResponseDeleteEvent = -4
+)
 
-func getDialogID() uint64 {
-	dialogIDLock.Lock()
-	defer dialogIDLock.Unlock()
+func (a *App) getDialogID(dialog interface{}) uint64 {
+	a.dialogsLock.Lock()
+	defer a.dialogsLock.Unlock()
 	dialogID := uint64(1)
 	for {
-		if _, ok := dialogMapID[dialogID]; !ok {
-			dialogMapID[dialogID] = struct{}{}
+		if _, ok := a.dialogs[dialogID]; !ok {
+			a.dialogs[dialogID] = dialog
 			break
 		}
 		dialogID++
@@ -54,10 +54,28 @@ func getDialogID() uint64 {
 	return dialogID
 }
 
-func freeDialogID(id uint64) {
-	dialogIDLock.Lock()
-	defer dialogIDLock.Unlock()
-	delete(dialogMapID, id)
+func (a *App) freeDialogID(id uint64) {
+	a.dialogsLock.Lock()
+	defer a.dialogsLock.Unlock()
+	delete(a.dialogs, id)
+}
+
+// closeDialogs dismisses all open dialogs, must be called on the main thread.
+func (a *App) closeDialogs() {
+	a.dialogsLock.RLock()
+	defer a.dialogsLock.RUnlock()
+	for _, dialog := range a.dialogs {
+		if d, ok := dialog.(interface{ dismiss() }); ok {
+			d.dismiss()
+		}
+	}
+}
+
+// dialogDismiss ends a running dialog as if it was closed by the user.
+func dialogDismiss(dialog windowPtr) {
+	if dialog != 0 {
+		lib.gtk.DialogResponse(dialog, GtkResponseDeleteEvent)
+	}
 }
 
 type Dialog struct {
@@ -118,7 +136,7 @@ func (d *Dialog) open(title string) *OpenFileDialog {
 	return &OpenFileDialog{
 		app:                  d.app,
 		window:               d.window,
-		log:                  newLogFunc("open-dialog"),
+		log:                  d.app.logger.With("component", "open-dialog"),
 		title:                title,
 		canChooseDirectories: false,
 		canChooseFiles:       true,
@@ -131,7 +149,7 @@ func (d *Dialog) save(title string) *SaveFileDialog {
 	return &SaveFileDialog{
 		app:                  d.app,
 		window:               d.window,
-		log:                  newLogFunc("save-dialog"),
+		log:                  d.app.logger.With("component", "save-dialog"),
 		title:                title,
 		canCreateDirectories: true,
 	}
@@ -163,7 +181,7 @@ func (d *Dialog) message(dtype int, title string, message string, actions []stri
 	return &MessageDialog{
 		app:     d.app,
 		window:  d.window,
-		log:     newLogFunc("msg-dialog"),
+		log:     d.app.logger.With("component", "msg-dialog"),
 		dtype:   dtype,
 		title:   title,
 		message: message,
@@ -180,8 +198,9 @@ type dialogMessageButton struct {
 
 type MessageDialog struct {
 	app    *App
-	log    logFunc
+	log    *slog.Logger
 	id     atomic.Uint64
+	native windowPtr
 	result chan int
 
 	dtype   int
@@ -194,14 +213,16 @@ type MessageDialog struct {
 
 func (d *MessageDialog) run() {
 	if d.id.Load() == 0 {
-		id := getDialogID()
+		d.app.inflight.add()
+		defer d.app.inflight.done()
+		id := d.app.getDialogID(d)
 		d.id.Store(id)
 		defer func() {
-			freeDialogID(id)
+			d.app.freeDialogID(id)
 			d.id.Store(0)
-			d.log("free", "id", id)
+			d.log.Debug("free", "id", id)
 		}()
-		d.log("open", "id", id, "title", d.title, "message", d.message)
+		d.log.Debug("open", "id", id, "title", d.title, "message", d.message)
 		action := d.app.thread.InvokeSyncWithResult(func() any {
 			return runMessageDialog(d)
 		})
@@ -217,6 +238,10 @@ func (d *MessageDialog) run() {
 	}
 }
 
+func (d *MessageDialog) dismiss() {
+	dialogDismiss(d.native)
+}
+
 func (d *MessageDialog) SetIcon(icon []byte) *MessageDialog {
 	d.icon = icon
 	return d
@@ -270,10 +295,11 @@ type dialogFileFilter struct {
 type OpenFileDialog struct {
 	app    *App
 	window *Window
+	native windowPtr
 	result chan []string
 
 	id  atomic.Uint64
-	log logFunc
+	log *slog.Logger
 
 	title                           string
 	message                         string
@@ -294,14 +320,16 @@ type OpenFileDialog struct {
 
 func (d *OpenFileDialog) run() {
 	if d.id.Load() == 0 {
-		id := getDialogID()
+		d.app.inflight.add()
+		defer d.app.inflight.done()
+		id := d.app.getDialogID(d)
 		d.id.Store(id)
 		defer func() {
-			freeDialogID(id)
+			d.app.freeDialogID(id)
 			d.id.Store(0)
-			d.log("free", "id", id)
+			d.log.Debug("free", "id", id)
 		}()
-		d.log("open", "id", id, "title", d.title, "message", d.message, "directory", d.directory, "buttonText", d.buttonText, "filters", d.filters)
+		d.log.Debug("open", "id", id, "title", d.title, "message", d.message, "directory", d.directory, "buttonText", d.buttonText, "filters", d.filters)
 		selections, err := d.app.thread.InvokeSyncWithResultAndError(func() (any, error) {
 			return runOpenFileDialog(d)
 		})
@@ -361,6 +389,10 @@ func (d *OpenFileDialog) ResolvesAliases(resolvesAliases bool) *OpenFileDialog {
 	return d
 }
 
+func (d *OpenFileDialog) dismiss() {
+	dialogDismiss(d.native)
+}
+
 // AddFilter adds a filter to the dialog. The filter is a display name and a semicolon separated list of extensions.
 // EG: AddFilter("Image Files", "*.jpg;*.png")
 func (d *OpenFileDialog) AddFilter(displayName, pattern string) *OpenFileDialog {
@@ -403,10 +435,11 @@ func (d *OpenFileDialog) Show(callbacks ...func([]string)) chan []string {
 
 type SaveFileDialog struct {
 	id  atomic.Uint64
-	log logFunc
+	log *slog.Logger
 
 	app    *App
 	window *Window
+	native windowPtr
 	result chan string
 
 	canCreateDirectories            bool
@@ -425,14 +458,16 @@ type SaveFileDialog struct {
 
 func (d *SaveFileDialog) run() {
 	if d.id.Load() == 0 {
-		id := getDialogID()
+		d.app.inflight.add()
+		defer d.app.inflight.done()
+		id := d.app.getDialogID(d)
 		d.id.Store(id)
 		defer func() {
-			freeDialogID(id)
+			d.app.freeDialogID(id)
 			d.id.Store(0)
-			d.log("free", "id", id)
+			d.log.Debug("free", "id", id)
 		}()
-		d.log("open", "id", id, "title", d.title, "message", d.message, "directory", d.directory, "filename", d.filename, "buttonText", d.buttonText, "filters", d.filters)
+		d.log.Debug("open", "id", id, "title", d.title, "message", d.message, "directory", d.directory, "filename", d.filename, "buttonText", d.buttonText, "filters", d.filters)
 		selections, err := d.app.thread.InvokeSyncWithResultAndError(func() (any, error) {
 			return runSaveFileDialog(d)
 		})
@@ -448,6 +483,10 @@ func (d *SaveFileDialog) run() {
 	}
 }
 
+func (d *SaveFileDialog) dismiss() {
+	dialogDismiss(d.native)
+}
+
 // AddFilter adds a filter to the dialog. The filter is a display name and a semicolon separated list of extensions.
 // EG: AddFilter("Image Files", "*.jpg;*.png")
 func (d *SaveFileDialog) AddFilter(displayName, pattern string) *SaveFileDialog {
@@ -518,7 +557,7 @@ func (d *SaveFileDialog) Show(callbacks ...func(string)) chan string {
 	return d.result
 }
 
-func runChooserDialog(window windowPtr, allowMultiple, createFolders, showHidden bool, currentFolder, title string, action int, acceptLabel string, filters []dialogFileFilter) ([]string, error) {
+func runChooserDialog(native *windowPtr, window windowPtr, allowMultiple, createFolders, showHidden bool, currentFolder, title string, action int, acceptLabel string, filters []dialogFileFilter) ([]string, error) {
 	GtkResponseCancel := 0
 	GtkResponseAccept := 1
 
@@ -531,6 +570,8 @@ func runChooserDialog(window windowPtr, allowMultiple, createFolders, showHidden
 		acceptLabel,
 		GtkResponseAccept,
 		0)
+	*native = fc
+	defer func() { *native = 0 }()
 
 	lib.gtk.FileChooserSetAction(fc, action)
 
@@ -595,6 +636,7 @@ func runOpenFileDialog(d *OpenFileDialog) ([]string, error) {
 		buttonText = "_Open"
 	}
 	return runChooserDialog(
+		&d.native,
 		window,
 		d.allowsMultipleSelection,
 		d.canCreateDirectories,
@@ -656,6 +698,8 @@ func runMessageDialog(d *MessageDialog) int {
 			lib.gtk.DialogSetDefaultResponse(dialog, i)
 		}
 	}
+	d.native = dialog
+	defer func() { d.native = 0 }()
 	defer lib.gtk.WidgetDestroy(dialog)
 	return lib.gtk.DialogRun(dialog)
 }
@@ -670,6 +714,7 @@ func runSaveFileDialog(d *SaveFileDialog) (string, error) {
 		buttonText = "_Save"
 	}
 	results, err := runChooserDialog(
+		&d.native,
 		window,
 		false, // multiple selection
 		d.canCreateDirectories,
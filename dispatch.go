@@ -0,0 +1,333 @@
+package webkitgtk
+
+import (
+	"context"
+	"errors"
+	"github.com/ebitengine/purego"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNotRunning is returned by App.DispatchSync if the function could not be run because
+// the main loop of the application stopped.
+var ErrNotRunning = errors.New("application is not running")
+
+// DispatchPriority is the order in which functions dispatched to the main thread are run.
+// Functions with the same priority are run in the order they were dispatched.
+type DispatchPriority int
+
+const (
+	PriorityHigh DispatchPriority = iota
+	PriorityDefault
+	PriorityLow
+)
+
+// dispatchBatchSize is the maximum number of functions run per main loop iteration
+// before control is given back to GTK to process events and redraws.
+const dispatchBatchSize = 64
+
+// Dispatch schedules fn to run on the main thread and returns immediately. Functions
+// dispatched before the application started are run once it is started, in the order
+// they were dispatched. Functions dispatched after the application stopped are dropped,
+// in the cases where DispatchSync returns ErrNotRunning.
+func (a *App) Dispatch(fn func()) {
+	a.DispatchWithPriority(PriorityDefault, fn)
+}
+
+// DispatchWithPriority schedules fn to run on the main thread with the given priority.
+func (a *App) DispatchWithPriority(priority DispatchPriority, fn func()) {
+	started := a.started.runStarted(runnableFunc(func() {
+		a.thread.enqueue(priority, fn)
+	}))
+	if !started {
+		a.log.Debug("dispatch after the application stopped")
+	}
+}
+
+// DispatchSync runs fn on the main thread and waits for it to return. If ctx is done
+// before fn started, fn is skipped and the context error is returned. When called from
+// the main thread fn is run directly. ErrNotRunning is returned if the application
+// stopped before fn was run.
+func (a *App) DispatchSync(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.thread != nil && a.thread.Running() {
+		fn()
+		return nil
+	}
+	done := make(chan error, 1)
+	started := a.started.runStarted(runnableFunc(func() {
+		ok := a.thread.push(PriorityDefault, &dispatchNode{
+			fn: func() {
+				if err := ctx.Err(); err != nil {
+					done <- err
+					return
+				}
+				defer close(done)
+				fn()
+			},
+			skip: func() {
+				done <- ErrNotRunning
+			},
+		})
+		if !ok {
+			done <- ErrNotRunning
+		}
+	}))
+	if !started {
+		return ErrNotRunning
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runnableFunc is the runnable of a dispatch. It must not block since deferred dispatches
+// are run synchronously when the application starts.
+type runnableFunc func()
+
+func (fn runnableFunc) run() {
+	fn()
+}
+
+// dispatchNode is an entry of the dispatch queue.
+type dispatchNode struct {
+	next atomic.Pointer[dispatchNode]
+	fn   func()
+	skip func() // skip is called instead of fn if the node is discarded by stop
+}
+
+// dispatchQueue is an unbounded lock-free multi-producer single-consumer queue. Any
+// goroutine may push while only the main thread pops.
+type dispatchQueue struct {
+	head atomic.Pointer[dispatchNode] // head is the last pushed node
+	tail *dispatchNode                // tail is the next node to pop, only accessed by the consumer
+	stub dispatchNode
+}
+
+func (q *dispatchQueue) init() {
+	q.head.Store(&q.stub)
+	q.tail = &q.stub
+}
+
+func (q *dispatchQueue) push(node *dispatchNode) {
+	node.next.Store(nil)
+	prev := q.head.Swap(node)
+	prev.next.Store(node)
+}
+
+// pop returns the next node or nil if the queue is empty or a producer has not
+// finished linking its node yet.
+func (q *dispatchQueue) pop() *dispatchNode {
+	tail := q.tail
+	next := tail.next.Load()
+	if tail == &q.stub {
+		if next == nil {
+			return nil
+		}
+		q.tail = next
+		tail = next
+		next = next.next.Load()
+	}
+	if next != nil {
+		q.tail = next
+		return tail
+	}
+	if tail != q.head.Load() {
+		return nil
+	}
+	q.push(&q.stub)
+	next = tail.next.Load()
+	if next != nil {
+		q.tail = next
+		return tail
+	}
+	return nil
+}
+
+// mainThreads maps the id passed as user data of the dispatch callback to the main thread.
+var mainThreads sync.Map
+
+var mainThreadIDs atomic.Uint64
+
+// dispatchCallback is the single idle callback shared by all main threads. It is created
+// once since purego callbacks can not be freed.
+var dispatchCallback = sync.OnceValue(func() ptr {
+	return ptr(purego.NewCallback(dispatchIdle))
+})
+
+// dispatchIdle drains the main thread registered with the key passed as user data.
+func dispatchIdle(data uintptr) int {
+	mt, ok := mainThreads.Load(uint64(data))
+	if !ok {
+		return gSourceRemove
+	}
+	return mt.(*mainThread).drain()
+}
+
+type mainThread struct {
+	log     *slog.Logger
+	id      uint64 // id is the id of the GLib thread
+	key     uint64 // key is passed as user data to the dispatch callback
+	queues  [PriorityLow + 1]dispatchQueue
+	pending atomic.Int64 // pending is the number of queued functions
+	waking  atomic.Bool  // waking is set while the dispatch callback is scheduled
+
+	stopLock sync.RWMutex // stopLock makes the stopped check and the push of enqueue atomic
+	stopped  bool
+}
+
+func newMainThread(log *slog.Logger) *mainThread {
+	mt := &mainThread{
+		log: log,
+		id:  lib.g.ThreadSelf(),
+		key: mainThreadIDs.Add(1),
+	}
+	for i := range mt.queues {
+		mt.queues[i].init()
+	}
+	mainThreads.Store(mt.key, mt)
+	return mt
+}
+
+func (mt *mainThread) ID() uint64 {
+	return mt.id
+}
+
+func (mt *mainThread) Running() bool {
+	return mt.id == lib.g.ThreadSelf()
+}
+
+// stop discards all queued functions and rejects further ones. Waiters of discarded
+// functions are released by their skip callback. Must be called on the main thread once
+// the main loop returned.
+func (mt *mainThread) stop() {
+	mt.stopLock.Lock()
+	mt.stopped = true
+	mt.stopLock.Unlock()
+	mainThreads.Delete(mt.key)
+
+	// all pushes completed while holding the lock, so no node is left half linked
+	var discarded int
+	for node := mt.next(); node != nil; node = mt.next() {
+		mt.pending.Add(-1)
+		discarded++
+		if node.skip != nil {
+			node.skip()
+		}
+	}
+	if discarded > 0 {
+		mt.log.Warn("main thread stopped with pending functions", "pending", discarded)
+	}
+}
+
+// enqueue adds fn to the queue of the given priority and wakes up the main loop. It
+// reports false if the main thread is stopped.
+func (mt *mainThread) enqueue(priority DispatchPriority, fn func()) bool {
+	return mt.push(priority, &dispatchNode{fn: fn})
+}
+
+// push adds the node to the queue of the given priority and wakes up the main loop. It
+// reports false if the main thread is stopped.
+func (mt *mainThread) push(priority DispatchPriority, node *dispatchNode) bool {
+	mt.stopLock.RLock()
+	defer mt.stopLock.RUnlock()
+	if mt.stopped {
+		mt.log.Debug("dispatch to stopped main thread")
+		return false
+	}
+	priority = max(PriorityHigh, min(priority, PriorityLow))
+	mt.pending.Add(1)
+	mt.queues[priority].push(node)
+	if mt.waking.CompareAndSwap(false, true) {
+		lib.g.IdleAddFull(gPriorityDefaultIdle, dispatchCallback(), ptr(mt.key), 0)
+	}
+	return true
+}
+
+// drain runs up to dispatchBatchSize queued functions in order of priority. It returns
+// gSourceContinue if functions remain so GTK can handle events between the batches.
+func (mt *mainThread) drain() int {
+	for n := 0; n < dispatchBatchSize; n++ {
+		node := mt.next()
+		if node == nil {
+			break
+		}
+		mt.pending.Add(-1)
+		mt.run(node.fn)
+	}
+	if mt.pending.Load() > 0 {
+		return gSourceContinue
+	}
+	mt.waking.Store(false)
+	// a function may have been queued after the last check while still waking
+	if mt.pending.Load() > 0 && mt.waking.CompareAndSwap(false, true) {
+		return gSourceContinue
+	}
+	return gSourceRemove
+}
+
+func (mt *mainThread) next() *dispatchNode {
+	for i := range mt.queues {
+		if node := mt.queues[i].pop(); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+func (mt *mainThread) run(fn func()) {
+	defer panicHandlerRecover()
+	fn()
+}
+
+func (mt *mainThread) InvokeSync(fn func()) {
+	if mt.Running() {
+		defer panicHandlerRecover()
+		fn()
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if !mt.push(PriorityDefault, &dispatchNode{
+		fn: func() {
+			defer wg.Done()
+			defer panicHandlerRecover()
+			fn()
+		},
+		skip: wg.Done,
+	}) {
+		return
+	}
+	wg.Wait()
+}
+
+func (mt *mainThread) InvokeAsync(fn func()) {
+	mt.enqueue(PriorityDefault, fn)
+}
+
+func (mt *mainThread) InvokeSyncWithResult(fn func() any) (res any) {
+	mt.InvokeSync(func() {
+		res = fn()
+	})
+	return res
+}
+
+func (mt *mainThread) InvokeSyncWithError(fn func() error) (err error) {
+	mt.InvokeSync(func() {
+		err = fn()
+	})
+	return err
+}
+
+func (mt *mainThread) InvokeSyncWithResultAndError(fn func() (any, error)) (res any, err error) {
+	mt.InvokeSync(func() {
+		res, err = fn()
+	})
+	return res, err
+}
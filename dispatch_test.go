@@ -0,0 +1,368 @@
+package webkitgtk
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testLoop stands in for the GLib main loop. It replaces the GLib functions used by the
+// dispatcher with an idle source running on a locked OS thread, whose id stands in for
+// the id of the GLib thread.
+type testLoop struct {
+	mt     *mainThread
+	idle   chan uintptr
+	quit   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	exited atomic.Bool // exited is set before the thread of the loop is unlocked
+}
+
+func newTestLoop(tb testing.TB) *testLoop {
+	threadSelf, idleAddFull := lib.g.ThreadSelf, lib.g.IdleAddFull
+	l := &testLoop{
+		idle: make(chan uintptr, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	lib.g.ThreadSelf = func() uint64 {
+		id := uint64(syscall.Gettid())
+		if l.exited.Load() && id == l.mt.id {
+			return 0 // the thread is reused by other goroutines once the loop exited
+		}
+		return id
+	}
+	lib.g.IdleAddFull = func(_ int, _ ptr, data ptr, _ ptr) uint {
+		// at most one idle source is scheduled per main thread, it is only dropped
+		// once the loop quit
+		select {
+		case l.idle <- uintptr(data):
+		default:
+		}
+		return 1
+	}
+
+	ready := make(chan *mainThread)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		defer close(l.done)
+		defer l.exited.Store(true)
+		ready <- newMainThread(slog.New(discardHandler{}))
+		for {
+			select {
+			case data := <-l.idle:
+				for dispatchIdle(data) == gSourceContinue {
+				}
+			case <-l.quit:
+				return
+			}
+		}
+	}()
+	l.mt = <-ready
+
+	tb.Cleanup(func() {
+		l.stop()
+		lib.g.ThreadSelf, lib.g.IdleAddFull = threadSelf, idleAddFull
+	})
+	return l
+}
+
+// quitLoop stops the loop without stopping the main thread, queued functions are kept.
+func (l *testLoop) quitLoop() {
+	l.once.Do(func() {
+		close(l.quit)
+	})
+	<-l.done
+}
+
+// stop quits the loop and stops the main thread like the shutdown of the app.
+func (l *testLoop) stop() {
+	l.quitLoop()
+	l.mt.stop()
+}
+
+// stubLib replaces a function of the lib tables for the duration of the test.
+func stubLib[T any](tb testing.TB, fn *T, stub T) {
+	old := *fn
+	*fn = stub
+	tb.Cleanup(func() {
+		*fn = old
+	})
+}
+
+// onMainThread fails the test if it is not called on the main thread of the loop.
+func (l *testLoop) onMainThread(tb testing.TB, name string) {
+	if !l.mt.Running() {
+		tb.Errorf("%s called outside the main thread", name)
+	}
+}
+
+func TestDispatchQueue(t *testing.T) {
+	const producers, pushes = 8, 10000
+	var q dispatchQueue
+	q.init()
+
+	// the nodes of every producer must be popped once and in the order they were pushed
+	var next [producers]int
+	var failed bool
+	popped := func(p, i int) {
+		if i != next[p] && !failed {
+			t.Errorf("producer %d: popped %d, want %d", p, i, next[p])
+			failed = true
+		}
+		next[p] = i + 1
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < pushes; i++ {
+				i := i
+				q.push(&dispatchNode{fn: func() {
+					popped(p, i)
+				}})
+			}
+		}(p)
+	}
+	for n := 0; n < producers*pushes; {
+		node := q.pop()
+		if node == nil {
+			runtime.Gosched()
+			continue
+		}
+		node.fn()
+		n++
+	}
+	wg.Wait()
+	if node := q.pop(); node != nil {
+		t.Error("queue not empty after all nodes were popped")
+	}
+}
+
+func TestMainThreadPriority(t *testing.T) {
+	t.Run("queued", func(t *testing.T) {
+		l := newTestLoop(t)
+
+		release := make(chan struct{})
+		l.mt.enqueue(PriorityDefault, func() {
+			<-release
+		})
+		var order []DispatchPriority
+		for _, priority := range []DispatchPriority{PriorityLow, PriorityDefault, PriorityHigh, PriorityLow, PriorityHigh} {
+			priority := priority
+			l.mt.enqueue(priority, func() {
+				order = append(order, priority)
+			})
+		}
+		close(release)
+
+		var got []DispatchPriority
+		done := make(chan struct{})
+		l.mt.enqueue(PriorityLow, func() {
+			got = order
+			close(done)
+		})
+		<-done
+		want := []DispatchPriority{PriorityHigh, PriorityHigh, PriorityDefault, PriorityLow, PriorityLow}
+		if len(got) != len(want) {
+			t.Fatalf("ran %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("ran %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("deferred", func(t *testing.T) {
+		l := newTestLoop(t)
+		a := &App{thread: l.mt}
+
+		// functions dispatched before the start run in order and before later ones
+		const before, after = 100, 100
+		var order []int
+		for i := 0; i < before+after; i++ {
+			i := i
+			if i == before {
+				a.started.invoke()
+			}
+			a.Dispatch(func() {
+				order = append(order, i)
+			})
+		}
+
+		var got []int
+		a.DispatchSync(context.Background(), func() {
+			got = order
+		})
+		if len(got) != before+after {
+			t.Fatalf("ran %d functions, want %d", len(got), before+after)
+		}
+		for i := range got {
+			if got[i] != i {
+				t.Fatalf("function %d ran at position %d", got[i], i)
+			}
+		}
+	})
+}
+
+func TestMainThreadInvokeSync(t *testing.T) {
+	l := newTestLoop(t)
+
+	const goroutines, calls = 16, 500
+	var counter int // only accessed on the main thread
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				l.mt.InvokeSync(func() {
+					l.onMainThread(t, "InvokeSync")
+					// nested calls on the main thread run directly
+					l.mt.InvokeSync(func() {
+						counter++
+					})
+				})
+				l.mt.InvokeAsync(func() {
+					counter++
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	var got int
+	l.mt.InvokeSync(func() {
+		got = counter
+	})
+	// async functions queued before the last InvokeSync have run
+	if want := 2 * goroutines * calls; got != want {
+		t.Fatalf("counter is %d, want %d", got, want)
+	}
+	if n := l.mt.pending.Load(); n != 0 {
+		t.Fatalf("%d functions pending", n)
+	}
+}
+
+func TestMainThreadStopReleasesWaiters(t *testing.T) {
+	l := newTestLoop(t)
+	l.quitLoop()
+
+	const waiters = 10
+	var wg sync.WaitGroup
+	var ran atomic.Int32
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.mt.InvokeSync(func() {
+				ran.Add(1)
+			})
+		}()
+	}
+	for l.mt.pending.Load() < waiters {
+		runtime.Gosched()
+	}
+	l.mt.stop()
+
+	released := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("InvokeSync waiters not released by stop")
+	}
+	if n := ran.Load(); n != 0 {
+		t.Errorf("%d discarded functions ran", n)
+	}
+	if l.mt.enqueue(PriorityDefault, func() {}) {
+		t.Error("enqueue accepted a function after stop")
+	}
+}
+
+func TestMainThreadStopRace(t *testing.T) {
+	for round := 0; round < 50; round++ {
+		l := newTestLoop(t)
+
+		var accepted, ran, skipped atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					if l.mt.push(PriorityDefault, &dispatchNode{
+						fn:   func() { ran.Add(1) },
+						skip: func() { skipped.Add(1) },
+					}) {
+						accepted.Add(1)
+					}
+				}
+			}()
+		}
+		l.stop()
+		wg.Wait()
+
+		if a, r, s := accepted.Load(), ran.Load(), skipped.Load(); a != r+s {
+			t.Fatalf("round %d: %d functions accepted, %d ran and %d skipped", round, a, r, s)
+		}
+		if n := l.mt.pending.Load(); n != 0 {
+			t.Fatalf("round %d: %d functions pending after stop", round, n)
+		}
+	}
+}
+
+func TestDispatchSyncNotRunning(t *testing.T) {
+	l := newTestLoop(t)
+	a := &App{log: slog.New(discardHandler{}), thread: l.mt}
+	a.started.invoke()
+
+	if err := a.DispatchSync(context.Background(), func() {}); err != nil {
+		t.Fatalf("DispatchSync while running: %v", err)
+	}
+
+	// shutdown as done by RunContext after the main loop returned
+	l.stop()
+	a.started.reset()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.DispatchSync(ctx, func() { t.Error("function ran after the app stopped") }); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("DispatchSync after shutdown: got %v, want %v", err, ErrNotRunning)
+	}
+	// Dispatch drops the function instead of deferring it to the next run
+	a.Dispatch(func() { t.Error("dispatched function ran after the app stopped") })
+	if n := len(a.started.runnables); n != 0 {
+		t.Fatalf("%d runnables deferred after shutdown", n)
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	l := newTestLoop(b)
+	a := &App{thread: l.mt}
+	a.started.invoke()
+
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.Dispatch(wg.Done)
+		}
+	})
+	wg.Wait()
+}
@@ -0,0 +1,45 @@
+package webkitgtk
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Emit sends an event with the JSON encoded payload to the listeners registered with
+// webkitAPI.on in the window. Events are delivered in the order they are emitted and
+// dropped if the page is not of one of the WindowOptions.AllowedOrigins.
+func (w *Window) Emit(name string, payload any) error {
+	js, err := emitScript(name, payload)
+	if err != nil {
+		return err
+	}
+	w.evalAPI("", js)
+	return nil
+}
+
+// Emit sends an event with the JSON encoded payload to all open windows whose page is of
+// one of their allowed origins.
+func (a *App) Emit(name string, payload any) error {
+	js, err := emitScript(name, payload)
+	if err != nil {
+		return err
+	}
+	for _, w := range a.Windows() {
+		w.evalAPI("", js)
+	}
+	return nil
+}
+
+// emitScript returns the script dispatching the event. Name and payload are embedded as
+// JSON literals so quotes and line breaks need no further escaping.
+func emitScript(name string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event payload: %w", err)
+	}
+	event, err := json.Marshal(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event name: %w", err)
+	}
+	return "window.webkitAPI && window.webkitAPI.emit(" + string(event) + "," + string(data) + ");", nil
+}
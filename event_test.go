@@ -0,0 +1,86 @@
+package webkitgtk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmitScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		payload any
+		want    string
+	}{
+		{"nil payload", "ready", nil, `window.webkitAPI && window.webkitAPI.emit("ready",null);`},
+		{"object payload", "progress", map[string]int{"done": 1}, `window.webkitAPI && window.webkitAPI.emit("progress",{"done":1});`},
+		{"quotes", "it's", `say "hi" and 'bye'`, `window.webkitAPI && window.webkitAPI.emit("it's","say \"hi\" and 'bye'");`},
+		{"newlines", "multi\nline", "a\nb\r\nc", `window.webkitAPI && window.webkitAPI.emit("multi\nline","a\nb\r\nc");`},
+		{"line separators", "sep", "a\u2028b\u2029c", `window.webkitAPI && window.webkitAPI.emit("sep","a\u2028b\u2029c");`},
+		{"script tags", "html", "</script><script>", `window.webkitAPI && window.webkitAPI.emit("html","\u003c/script\u003e\u003cscript\u003e");`},
+		{"backslashes", `a\b`, `\n`, `window.webkitAPI && window.webkitAPI.emit("a\\b","\\n");`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := emitScript(tt.event, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+			if strings.ContainsAny(got, "\n\r\u2028\u2029") {
+				t.Fatalf("script contains a raw line break: %q", got)
+			}
+		})
+	}
+}
+
+func TestEmitScriptNotEncodable(t *testing.T) {
+	if _, err := emitScript("event", make(chan int)); err == nil {
+		t.Fatal("expected an error for a payload that can not be encoded")
+	}
+}
+
+func TestEmitOrder(t *testing.T) {
+	l := newTestLoop(t)
+
+	var scripts []string // only accessed on the main thread
+	stubLib(t, &lib.webkit.WebViewEvaluateJavascript, func(_ webviewPtr, js string, _ int, _, _, _, _, _ ptr) {
+		l.onMainThread(t, "webkit_web_view_evaluate_javascript")
+		scripts = append(scripts, js)
+	})
+
+	a := &App{thread: l.mt, windows: make(map[uint]*Window)}
+	w := &Window{app: a, id: 1, webview: 1, token: "page"}
+	a.windows[w.id] = w
+	// the page of the second window is not of an allowed origin
+	a.windows[2] = &Window{app: a, id: 2, webview: 2}
+
+	const events = 100
+	for i := 0; i < events; i++ {
+		var err error
+		if i%2 == 0 {
+			err = w.Emit("count", i)
+		} else {
+			err = a.Emit("count", i)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	l.mt.InvokeSync(func() {
+		got = scripts
+	})
+	if len(got) != events {
+		t.Fatalf("%d events delivered, want %d", len(got), events)
+	}
+	for i, js := range got {
+		want, _ := emitScript("count", i)
+		if js != want {
+			t.Fatalf("event %d: got %s, want %s", i, js, want)
+		}
+	}
+}
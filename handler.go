@@ -197,6 +197,12 @@ func (r *uriSchemeRequest) toHttpRequest() (*http.Request, error) {
 	}
 	req.URL = reqUrl
 	req.Method = lib.webkit.UriSchemeRequestGetHttpMethod(r.pointer)
+	req.Header = http.Header{}
+	if headers := lib.webkit.UriSchemeRequestGetHttpHeaders(r.pointer); headers != 0 {
+		if origin := lib.soup.MessageHeadersGetOne(headers, "Origin"); origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+	}
 	req.Body = http.NoBody
 	reqBody := lib.webkit.UriSchemeRequestGetHttpBody(r.pointer)
 	if reqBody != 0 {
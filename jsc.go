@@ -2,7 +2,6 @@ package webkitgtk
 
 import (
 	"errors"
-	"github.com/ebitengine/purego"
 )
 
 // TODO: check for memory leaks in jsc.go
@@ -13,45 +12,71 @@ func (w *Window) JSPromise(js string, fn func(interface{})) func() {
 }
 
 func (w *Window) JSCall(js string, fn func(interface{})) func() {
-	cancelable := lib.g.CancellableNew()
-	lib.webkit.WebViewCallAsyncJavascriptFunction(
-		w.webview, js, len(js), 0, 0, 0, cancelable,
-		ptr(purego.NewCallback(func(webview webviewPtr, result ptr) {
-			var gErr *gError
-			jsc := lib.webkit.WebViewCallAsyncJavascriptFunctionFinish(webview, result, gErr)
-			fn(parseJSC(jsc, cancelable, gErr))
-			//lib.webkit.JavascriptResultUnref(result)
-		})), 0)
+	var cancelable ptr
+	var done bool // done is only accessed on the main thread
+	w.invoke(func() {
+		cancelable = lib.g.CancellableNew()
+		lib.webkit.WebViewCallAsyncJavascriptFunction(
+			w.webview, js, len(js), 0, 0, 0, cancelable,
+			callbackFunc2(),
+			callbacks.registerOnce(func(webview ptr, result ptr) {
+				var gErr *gError
+				jsc := lib.webkit.WebViewCallAsyncJavascriptFunctionFinish(webviewPtr(webview), result, gErr)
+				fn(parseJSC(w.app.thread, jsc, cancelable, gErr))
+				//lib.webkit.JavascriptResultUnref(result)
+				done = true
+				lib.g.ObjectUnref(cancelable)
+			}))
+	})
 	return func() {
-		lib.g.CancellableCancel(cancelable)
+		w.invoke(func() {
+			if !done {
+				lib.g.CancellableCancel(cancelable)
+			}
+		})
 	}
 }
 
 func (w *Window) JSEval(js string, fn func(interface{})) func() {
-	cancelable := lib.g.CancellableNew()
-	lib.webkit.WebViewEvaluateJavascript(
-		w.webview, js, len(js), 0, 0, cancelable,
-		ptr(purego.NewCallback(func(webview webviewPtr, result ptr) {
-			var gErr *gError
-			jsc := lib.webkit.WebViewEvaluateJavascriptFinish(webview, result, gErr)
-			fn(parseJSC(jsc, cancelable, gErr))
-			//lib.webkit.JavascriptResultUnref(result)
-		})), 0)
+	var cancelable ptr
+	var done bool // done is only accessed on the main thread
+	w.invoke(func() {
+		cancelable = lib.g.CancellableNew()
+		lib.webkit.WebViewEvaluateJavascript(
+			w.webview, js, len(js), 0, 0, cancelable,
+			callbackFunc2(),
+			callbacks.registerOnce(func(webview ptr, result ptr) {
+				var gErr *gError
+				jsc := lib.webkit.WebViewEvaluateJavascriptFinish(webviewPtr(webview), result, gErr)
+				fn(parseJSC(w.app.thread, jsc, cancelable, gErr))
+				//lib.webkit.JavascriptResultUnref(result)
+				done = true
+				lib.g.ObjectUnref(cancelable)
+			}))
+	})
 	return func() {
-		lib.g.CancellableCancel(cancelable)
+		w.invoke(func() {
+			if !done {
+				lib.g.CancellableCancel(cancelable)
+			}
+		})
 	}
 }
 
 type JSObject struct {
+	thread  *mainThread
 	pointer ptr
 }
 
-func (jso *JSObject) Get(name string) interface{} {
-	value := lib.jsc.ValueObjectGetProperty(jso.pointer, name)
-	return parseJSC(value, 0, nil)
+func (jso *JSObject) Get(name string) (result interface{}) {
+	jso.thread.InvokeSync(func() {
+		value := lib.jsc.ValueObjectGetProperty(jso.pointer, name)
+		result = parseJSC(jso.thread, value, 0, nil)
+	})
+	return result
 }
 
-func parseJSC(value ptr, cancelable ptr, gErr *gError) interface{} {
+func parseJSC(thread *mainThread, value ptr, cancelable ptr, gErr *gError) interface{} {
 	if value == 0 {
 		if lib.g.CancellableIsCancelled(cancelable) {
 			return errors.New("call canceled")
@@ -74,7 +99,7 @@ func parseJSC(value ptr, cancelable ptr, gErr *gError) interface{} {
 	} else if lib.jsc.ValueIsString(value) {
 		return lib.jsc.ValueToString(value)
 	} else if lib.jsc.ValueIsObject(value) {
-		return &JSObject{value}
+		return &JSObject{thread, value}
 	}
 	return errors.New("jscToValue: unknown type")
 }
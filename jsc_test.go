@@ -0,0 +1,102 @@
+package webkitgtk
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ebitengine/purego"
+)
+
+// TestJSEvalSoak runs 100k evaluations through the real purego trampolines with the
+// WebKit functions stubbed to complete on the main loop. Creating a purego callback per
+// evaluation panics after a few thousand calls, leaked registry entries or cancellables
+// are counted.
+func TestJSEvalSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	l := newTestLoop(t)
+
+	// only accessed on the main thread
+	var nextCancellable ptr
+	cancellables := make(map[ptr]bool)
+	trampolines := make(map[ptr]bool)
+
+	stubLib(t, &lib.g.CancellableNew, func() ptr {
+		l.onMainThread(t, "g_cancellable_new")
+		nextCancellable++
+		cancellables[nextCancellable] = true
+		return nextCancellable
+	})
+	stubLib(t, &lib.g.CancellableIsCancelled, func(ptr) bool { return false })
+	stubLib(t, &lib.g.ObjectUnref, func(object ptr) {
+		l.onMainThread(t, "g_object_unref")
+		if !cancellables[object] {
+			t.Errorf("cancellable %d released twice", object)
+		}
+		delete(cancellables, object)
+	})
+	complete := func(webview webviewPtr, callback ptr, data ptr) {
+		trampolines[callback] = true
+		l.mt.InvokeAsync(func() {
+			purego.SyscallN(uintptr(callback), uintptr(webview), 1, uintptr(data))
+		})
+	}
+	stubLib(t, &lib.webkit.WebViewEvaluateJavascript, func(webview webviewPtr, _ string, _ int, _, _, _ ptr, callback ptr, data ptr) {
+		complete(webview, callback, data)
+	})
+	stubLib(t, &lib.webkit.WebViewCallAsyncJavascriptFunction, func(webview webviewPtr, _ string, _ int, _, _, _, _ ptr, callback ptr, data ptr) {
+		complete(webview, callback, data)
+	})
+	finish := func(webviewPtr, ptr, *gError) ptr { return 1 }
+	stubLib(t, &lib.webkit.WebViewEvaluateJavascriptFinish, finish)
+	stubLib(t, &lib.webkit.WebViewCallAsyncJavascriptFunctionFinish, finish)
+	stubLib(t, &lib.jsc.ValueGetContext, func(ptr) ptr { return 1 })
+	stubLib(t, &lib.jsc.ContextGetException, func(ptr) ptr { return 0 })
+	stubLib(t, &lib.jsc.ValueIsNumber, func(ptr) bool { return true })
+	stubLib(t, &lib.jsc.ValueToDouble, func(ptr) float64 { return 42 })
+
+	callbacks.mutex.RLock()
+	registered := len(callbacks.fns)
+	callbacks.mutex.RUnlock()
+
+	w := &Window{app: &App{thread: l.mt}, webview: 1}
+	const goroutines, evaluations = 8, 100000
+	var wg sync.WaitGroup
+	wg.Add(evaluations)
+	result := func(v interface{}) {
+		defer wg.Done()
+		if v != 42.0 {
+			t.Errorf("evaluation returned %v", v)
+		}
+	}
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			for i := g; i < evaluations; i += goroutines {
+				if i%2 == 0 {
+					w.JSEval("42", result)
+				} else {
+					cancel := w.JSCall("return 42", result)
+					if i%10 == 1 {
+						cancel()
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	l.mt.InvokeSync(func() {
+		if len(trampolines) != 1 {
+			t.Errorf("%d trampolines used, want 1", len(trampolines))
+		}
+		if len(cancellables) != 0 {
+			t.Errorf("%d cancellables leaked", len(cancellables))
+		}
+	})
+	callbacks.mutex.RLock()
+	defer callbacks.mutex.RUnlock()
+	if n := len(callbacks.fns) - registered; n != 0 {
+		t.Errorf("%d callbacks leaked", n)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/godbus/dbus/v5"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -64,7 +65,7 @@ func (n *Notification) Closed(callback func()) *Notification {
 }
 
 type dbusNotify struct {
-	log  logFunc
+	log  *slog.Logger
 	conn *dbus.Conn
 
 	appName string
@@ -91,7 +92,6 @@ type dbusNotify struct {
 }
 
 func (n *dbusNotify) Start(conn *dbus.Conn) error {
-	n.log = newLogFunc("dbus-notify")
 	n.conn = conn
 	n.notifications = make(map[uint32]*Notification)
 	/////////////
@@ -109,7 +109,7 @@ func (n *dbusNotify) Start(conn *dbus.Conn) error {
 	if err != nil {
 		return fmt.Errorf("error getting notification server information: %w", err)
 	}
-	n.log("notification server information", "server", n.server, "vendor", n.vendor, "version", n.version, "specification", n.specification)
+	n.log.Debug("notification server information", "server", n.server, "vendor", n.vendor, "version", n.version, "specification", n.specification)
 
 	//var d = make(chan *dbus.Call, 1)
 	//var o = n.dbus.conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
@@ -157,8 +157,8 @@ func (n *dbusNotify) Start(conn *dbus.Conn) error {
 		}
 	}
 
-	n.log("notification server capabilities", "action-icons", n.actionIcons, "actions", n.actions, "body", n.body, "body-hyperlinks", n.bodyHyperlinks, "body-images", n.bodyImages, "body-markup", n.bodyMarkup, "icon-multi", n.iconMulti, "icon-static", n.iconStatic, "persistence", n.persistence, "sound", n.sound)
-	n.log("started")
+	n.log.Debug("notification server capabilities", "action-icons", n.actionIcons, "actions", n.actions, "body", n.body, "body-hyperlinks", n.bodyHyperlinks, "body-images", n.bodyImages, "body-markup", n.bodyMarkup, "icon-multi", n.iconMulti, "icon-static", n.iconStatic, "persistence", n.persistence, "sound", n.sound)
+	n.log.Info("started")
 	return nil
 }
 
@@ -227,9 +227,9 @@ func (n *dbusNotify) Signal(sig *dbus.Signal) {
 		delete(n.notifications, id)
 		n.notifications_.Unlock()
 
-		n.log("notification closed", "id", id, "reason", sig.Body[1].(uint32))
+		n.log.Debug("notification closed", "id", id, "reason", sig.Body[1].(uint32))
 		if !ok {
-			n.log("notification not found", "id", id)
+			n.log.Debug("notification not found", "id", id)
 			return
 		}
 
@@ -244,9 +244,9 @@ func (n *dbusNotify) Signal(sig *dbus.Signal) {
 		notification, ok := n.notifications[id]
 		n.notifications_.Unlock()
 
-		n.log("notification action invoked", "id", sig.Body[0].(uint32), "action", sig.Body[1].(string))
+		n.log.Debug("notification action invoked", "id", sig.Body[0].(uint32), "action", sig.Body[1].(string))
 		if !ok {
-			n.log("notification not found", "id", id)
+			n.log.Debug("notification not found", "id", id)
 			return
 		}
 
@@ -261,5 +261,5 @@ func (n *dbusNotify) Signal(sig *dbus.Signal) {
 }
 
 func (n *dbusNotify) Stop() {
-	n.log("stopped")
+	n.log.Info("stopped")
 }
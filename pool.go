@@ -0,0 +1,99 @@
+package webkitgtk
+
+import (
+	"sync"
+	"time"
+)
+
+// CallPolicy configures how the calls of a bound struct are executed.
+type CallPolicy struct {
+	// Workers limits the number of calls running concurrently, 0 uses the shared workers
+	// of the binding configured by WindowOptions.APIWorkers.
+	Workers int
+
+	// Timeout rejects calls running longer with a TimeoutError, 0 uses
+	// WindowOptions.APITimeout and a negative timeout disables it.
+	Timeout time.Duration
+
+	// Serial runs the calls one after another in the order they were received.
+	Serial bool
+}
+
+// APIPolicy is implemented by bound structs to configure the execution of their calls.
+// CallPolicy is called once when the struct is bound and is not callable from JavaScript.
+// Nested structs are also made serial with the serial option of the webkit tag:
+//
+//	Files *FilesAPI `webkit:"files,serial"`
+type APIPolicy interface {
+	CallPolicy() CallPolicy
+}
+
+// apiPool runs calls on a bounded number of goroutines. Calls beyond the limit are queued
+// and run in the order they were submitted.
+type apiPool struct {
+	mutex   sync.Mutex
+	workers int
+	running int
+	queue   []func()
+}
+
+func newAPIPool(workers int) *apiPool {
+	return &apiPool{workers: max(workers, 1)}
+}
+
+// submit runs fn on a worker. A nil pool runs fn on its own goroutine.
+func (p *apiPool) submit(fn func()) {
+	if p == nil {
+		go fn()
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.running < p.workers {
+		p.running++
+		go p.work(fn)
+		return
+	}
+	p.queue = append(p.queue, fn)
+}
+
+// work runs fn and then the queued functions until the queue is empty.
+func (p *apiPool) work(fn func()) {
+	for fn != nil {
+		fn()
+		p.mutex.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			fn = nil
+		} else {
+			fn = p.queue[0]
+			p.queue[0] = nil
+			p.queue = p.queue[1:]
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// assignPools assigns the worker pools to the methods of the binding. Methods share a pool
+// of the given number of workers unless the policy of their struct sets workers or serial
+// execution, in which case all methods of the struct share their own pool.
+func (api apiBinding) assignPools(workers int) {
+	shared := newAPIPool(workers)
+	pools := make(map[*CallPolicy]*apiPool)
+	for _, m := range api {
+		if m.policy == nil || (m.policy.Workers <= 0 && !m.policy.Serial) {
+			m.pool = shared
+			continue
+		}
+		pool, ok := pools[m.policy]
+		if !ok {
+			n := m.policy.Workers
+			if m.policy.Serial {
+				n = 1
+			}
+			pool = newAPIPool(n)
+			pools[m.policy] = pool
+		}
+		m.pool = pool
+	}
+}
@@ -0,0 +1,125 @@
+package webkitgtk
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+)
+
+// apiProtocolVersion is the version of the messages exchanged between the JS client and
+// the api handler. It is bumped on incompatible changes of apiRequest or apiMessage.
+const apiProtocolVersion = 1
+
+// Codes of the errors raised by the bridge itself rather than by a bound method.
+const (
+	CodeUnsupportedVersion = "unsupported_version" // the request was sent by an incompatible client
+	CodeNotFound           = "not_found"           // the api or function does not exist
+	CodeInvalidArguments   = "invalid_arguments"   // the arguments could not be decoded
+	CodeEncoding           = "encoding"            // the result could not be encoded
+	CodeTimeout            = "timeout"             // the call did not return within its timeout
+)
+
+// JSError is implemented by errors returned from bound methods to populate the name, code
+// and data of the JavaScript Error the call is rejected with. The message of the Error is
+// the result of the Error method. Wrapped errors are found with errors.As.
+type JSError interface {
+	error
+	JSError() (name string, code string, data any)
+}
+
+// CallError is a JSError for bound methods to return. Data is encoded as JSON.
+type CallError struct {
+	Name    string // Name is the name of the JavaScript Error, "Error" if empty
+	Code    string // Code is a machine readable code of the error
+	Message string // Message is the message of the error, defaults to the message of Err
+	Data    any    // Data are additional details of the error
+	Err     error  // Err is the underlying error
+}
+
+func (e *CallError) Error() string {
+	if e.Message == "" && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *CallError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CallError) JSError() (string, string, any) {
+	return e.Name, e.Code, e.Data
+}
+
+// apiRequest is the envelope of a call sent by the JS client.
+type apiRequest struct {
+	V     int             `json:"v"`
+	Token string          `json:"token"` // Token is the token of the page the client was injected into
+	ID    uint64          `json:"id"`
+	API   string          `json:"api"`
+	Fn    string          `json:"fn"`
+	Args  json.RawMessage `json:"args,omitempty"`
+}
+
+// apiCancel is the message sent by the JS client when a call is aborted.
+type apiCancel struct {
+	Token string `json:"token"`
+	ID    uint64 `json:"id"`
+}
+
+// apiMessage is the envelope of a message sent to the JS client.
+type apiMessage struct {
+	V      int             `json:"v"`
+	ID     uint64          `json:"id"`
+	Type   string          `json:"type"`             // Type is one of resolve, reject, blob, push or end
+	Result json.RawMessage `json:"result,omitempty"` // Result is the value of resolve and push messages
+	Error  *apiError       `json:"error,omitempty"`  // Error is set for reject and failed end messages
+	Token  string          `json:"token,omitempty"`  // Token is the blob to fetch for blob messages
+	Blob   bool            `json:"blob,omitempty"`   // Blob indicates the blob is returned as Blob instead of ArrayBuffer
+}
+
+// apiError is the encoding of an error as the properties of a JavaScript Error.
+type apiError struct {
+	Name    string `json:"name"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func newAPIError(err error) *apiError {
+	e := &apiError{Name: "Error", Message: err.Error()}
+	var jsErr JSError
+	if errors.As(err, &jsErr) {
+		name, code, data := jsErr.JSError()
+		if name != "" {
+			e.Name = name
+		}
+		e.Code = code
+		e.Data = data
+	}
+	return e
+}
+
+// apiSend evaluates the message in the page. JSON is a subset of JavaScript and
+// encoding/json escapes U+2028 and U+2029, so the message is embedded as a literal
+// without further escaping.
+func apiSend(eval func(string), log *slog.Logger, msg apiMessage) {
+	msg.V = apiProtocolVersion
+	data, err := json.Marshal(msg)
+	if err != nil && msg.Error != nil && msg.Error.Data != nil {
+		log.Warn("api error data not encodable", "id", msg.ID, "error", err)
+		msg.Error.Data = nil
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		log.Error("api message not encodable", "id", msg.ID, "error", err)
+		return
+	}
+	eval("webkitAPI.receive(" + string(data) + ")")
+}
+
+// apiReject sends the error as the rejection of the call.
+func apiReject(eval func(string), log *slog.Logger, id uint64, err error) {
+	log.Warn("api reject", "id", id, "error", err)
+	apiSend(eval, log, apiMessage{ID: id, Type: "reject", Error: newAPIError(err)})
+}
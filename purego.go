@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"time"
+	"unsafe"
 )
 
 type (
@@ -65,7 +66,11 @@ func (gErr *gError) toError(msg string) error {
 }
 
 const (
-	gSourceRemove int = 0
+	gSourceRemove   int = 0
+	gSourceContinue int = 1
+
+	gPriorityDefault     = 0
+	gPriorityDefaultIdle = 200
 
 	gdkHintMinSize = 1 << 1
 	gdkHintMaxSize = 1 << 2
@@ -75,6 +80,9 @@ const (
 	gdkWindowStateFullscreen = 1 << 4
 
 	gtkOrientationVertical = 1
+
+	gApplicationHandlesCommandLine = 1 << 3
+	gApplicationNonUnique          = 1 << 5
 )
 
 var libs = [][]string{
@@ -85,6 +93,7 @@ var libs = [][]string{
 var lib struct {
 	Target  string
 	Version int
+	Loaded  bool
 
 	GTK    uintptr
 	Webkit uintptr
@@ -92,22 +101,26 @@ var lib struct {
 	g struct {
 		ApplicationHold        func(ptr)
 		ApplicationQuit        func(ptr)
-		ApplicationRegister    func(ptr, ptr, ptr)
+		ApplicationRegister    func(ptr, ptr, *gError) bool
 		ApplicationActivate    func(ptr)
 		GetApplicationName     func() string
 		ApplicationIdIsValid   func(string) bool
 		ApplicationRelease     func(ptr)
-		ApplicationRun         func(ptr, int, []string) int
+		ApplicationRun         func(ptr, int, **byte) int
+		ApplicationGetIsRemote func(ptr) bool
 		BytesNewStatic         func(uintptr, int) uintptr
 		BytesUnref             func(uintptr)
 		Free                   func(ptr)
 		IdleAdd                func(uintptr)
+		IdleAddFull            func(int, ptr, ptr, ptr) uint
+		TimeoutAddFull         func(int, uint, ptr, ptr, ptr) uint
+		SourceRemove           func(uint) bool
 		ObjectRef              func(ptr)
 		ObjectRefSink          func(ptr)
 		ObjectUnref            func(ptr)
 		ObjectSet              func(ptr, string, ptr)
 		ObjectGet              func(ptr, string, ptr)
-		SignalConnectData      func(ptr, string, uintptr, ptr, bool, int) int
+		SignalConnectData      func(ptr, string, ptr, ptr, ptr, int) uint
 		SignalConnectObject    func(ptr, string, ptr, ptr, int) uint
 		SignalHandlerBlock     func(ptr, uint)
 		SignalHandlerUnblock   func(ptr, uint)
@@ -125,6 +138,11 @@ var lib struct {
 		CancellableNew         func() ptr
 		CancellableCancel      func(ptr)
 		CancellableIsCancelled func(ptr) bool
+
+		ApplicationCommandLineGetArguments func(ptr, *int) **byte
+		ApplicationCommandLineGetCwd       func(ptr) string
+		ApplicationCommandLineGetIsRemote  func(ptr) bool
+		Strfreev                           func(**byte)
 	}
 	gdk struct {
 		DisplayGetMonitor         func(ptr, int) ptr
@@ -156,6 +174,7 @@ var lib struct {
 		CssProviderNew               func() ptr
 		DialogAddButton              func(windowPtr, string, int)
 		DialogGetContentArea         func(windowPtr) windowPtr
+		DialogResponse               func(windowPtr, int)
 		DialogRun                    func(windowPtr) int
 		DialogSetDefaultResponse     func(windowPtr, int)
 		DragDestSet                  func(webviewPtr, uint, ptr, uint, uint)
@@ -220,6 +239,7 @@ var lib struct {
 	soup struct {
 		MessageHeadersNew    func(int) ptr
 		MessageHeadersAppend func(ptr, string, string)
+		MessageHeadersGetOne func(ptr, string) string
 	}
 	webkitSettings struct {
 		GetEnableJavascript                          func(webkitSettingsPtr) bool
@@ -377,7 +397,7 @@ var lib struct {
 	webkit struct {
 		WebViewNewWithContext            func(ptr) webviewPtr
 		WebViewNewWithUserContentManager func(userContentManagerPtr) webviewPtr
-		WebContextRegisterUriScheme      func(ptr, string, ptr, int, int)
+		WebContextRegisterUriScheme      func(ptr, string, ptr, ptr, ptr)
 		//webkitSettingsGetEnableDeveloperExtras                  func(pointer) bool
 		//webkitSettingsSetHardwareAccelerationPolicy             func(pointer, int)
 		//webkitSettingsSetEnableDeveloperExtras                  func(pointer, bool)
@@ -422,6 +442,7 @@ var lib struct {
 		WebViewCallAsyncJavascriptFunctionFinish func(webviewPtr, ptr, *gError) ptr
 		WebViewGetSettings                       func(webviewPtr) webkitSettingsPtr
 		WebViewGetZoomLevel                      func(webviewPtr) float64
+		WebViewGetUri                            func(webviewPtr) string
 		//WebViewLoadAlternateHtml  func(webviewPtr, string, string, *string)
 		WebViewLoadUri                     func(webviewPtr, string)
 		WebViewLoadHtml                    func(webviewPtr, string, string)
@@ -434,6 +455,7 @@ var lib struct {
 		UriSchemeRequestGetHttpMethod      func(ptr) string
 		UriSchemeRequestGetHttpHeaders     func(ptr) ptr
 		UriSchemeRequestGetHttpBody        func(ptr) ptr
+		UriSchemeRequestGetWebView         func(ptr) webviewPtr
 		UriSchemeRequestFinish             func(ptr, ptr, int, string)
 		UriSchemeRequestFinishError        func(ptr, *gError)
 		UriSchemeRequestFinishWithResponse func(ptr, ptr)
@@ -445,6 +467,35 @@ var lib struct {
 	}
 }
 
+// cStrings converts the given strings to a NULL terminated array of C strings.
+func cStrings(ss []string) []*byte {
+	cs := make([]*byte, len(ss)+1)
+	for i, s := range ss {
+		b := append([]byte(s), 0)
+		cs[i] = &b[0]
+	}
+	return cs
+}
+
+// goStrings converts a NULL terminated array of C strings of the given length.
+func goStrings(cs **byte, n int) []string {
+	if cs == nil {
+		return nil
+	}
+	ss := make([]string, 0, n)
+	for _, c := range unsafe.Slice(cs, n) {
+		if c == nil {
+			break
+		}
+		var b []byte
+		for p := unsafe.Pointer(c); *(*byte)(p) != 0; p = unsafe.Add(p, 1) {
+			b = append(b, *(*byte)(p))
+		}
+		ss = append(ss, string(b))
+	}
+	return ss
+}
+
 func registerFunctions(lib uintptr, prefix string, v interface{}) error {
 	if reflect.TypeOf(v).Kind() != reflect.Pointer {
 		return fmt.Errorf("v must be a struct pointer")
@@ -537,14 +588,18 @@ func findSharedLib(target string, names []string) []string {
 }
 
 func (a *App) loadSharedLibs() error {
-	a.log("loading shared libraries", "GOOS", runtime.GOOS, "GOARCH", runtime.GOARCH)
+	if lib.Loaded {
+		a.log.Debug("shared libraries already loaded")
+		return nil
+	}
+	a.log.Debug("loading shared libraries", "GOOS", runtime.GOOS, "GOARCH", runtime.GOARCH)
 	loadTime := time.Now()
 
 	// 1. Locate shared libraries
 	target := getLibTarget()
 	var libPaths []string
 	for i, names := range libs {
-		a.log("locating shared libraries", "target", target, "libs", names)
+		a.log.Debug("locating shared libraries", "target", target, "libs", names)
 		paths := findSharedLib(target, names)
 		if paths != nil {
 			libPaths = paths
@@ -558,12 +613,12 @@ func (a *App) loadSharedLibs() error {
 
 	// 2. Load shared libraries
 	var err error
-	a.log("loading gtk library", "path", libPaths[0])
+	a.log.Debug("loading gtk library", "path", libPaths[0])
 	lib.GTK, err = purego.Dlopen(libPaths[0], purego.RTLD_NOW|purego.RTLD_GLOBAL)
 	if err != nil {
 		return fmt.Errorf("unable to load gtk library: %w", err)
 	}
-	a.log("loading webkit library", "path", libPaths[1])
+	a.log.Debug("loading webkit library", "path", libPaths[1])
 	lib.Webkit, err = purego.Dlopen(libPaths[1], purego.RTLD_NOW|purego.RTLD_GLOBAL)
 	if err != nil {
 		return fmt.Errorf("unable to load webkit library: %w", err)
@@ -593,13 +648,14 @@ func (a *App) loadSharedLibs() error {
 	}
 	err = registerFunctions(lib.Webkit, "webkit", &lib.webkit)
 	if err != nil {
-		a.log("unable to register webkit functions", "error", err)
+		a.log.Warn("unable to register webkit functions", "error", err)
 	}
 	err = registerFunctions(lib.Webkit, "webkit_settings", &lib.webkitSettings)
 	if err != nil {
-		a.log("unable to register webkit_settings functions", "error", err)
+		a.log.Warn("unable to register webkit_settings functions", "error", err)
 	}
 
-	a.log("shared libraries loaded", "in", time.Since(loadTime), "paths", libPaths)
+	lib.Loaded = true
+	a.log.Info("shared libraries loaded", "in", time.Since(loadTime), "paths", libPaths)
 	return nil
 }
@@ -4,5 +4,4 @@ package webkitgtk
 
 func init() {
 	_RELEASE = true
-	LogWriter = nil
 }
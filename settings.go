@@ -1,5 +1,10 @@
 package webkitgtk
 
+import (
+	"log/slog"
+	"time"
+)
+
 type AppOptions struct {
 
 	// ID is the unique identifier of the app in reverse domain notation. e.g. com.github.malivvan.webkitgtk
@@ -11,9 +16,23 @@ type AppOptions struct {
 	// Hold the app open after the last window is closed.
 	Hold bool
 
+	// SingleInstance allows only one running instance of the app per user. Launching
+	// the app again focuses the running instance and forwards the command line
+	// arguments to the callback registered with App.OnSecondInstance.
+	SingleInstance bool
+
+	// URLSchemes are the URL schemes (e.g. myapp for myapp://open?doc=123) the app is
+	// registered as handler for in its desktop entry. Opened URLs are passed to the
+	// callback registered with App.OnOpenURL. URLSchemes implies SingleInstance, so URLs
+	// opened while the app is running are forwarded to the running instance.
+	URLSchemes []string
+
 	// The icon of the app.
 	Icon []byte
 
+	// Desktop is the desktop entry of the app. If set it is installed on the first run.
+	Desktop *DesktopEntry
+
 	// Ephemeral mode disables all persistent storage.
 	Ephemeral bool
 
@@ -28,6 +47,18 @@ type AppOptions struct {
 
 	// CookiePolicy is the cookie store used by the webview.
 	CookiePolicy WebkitCookiePolicy
+
+	// Logger is the structured logger used by the app. Default: text logs to stderr,
+	// discarded in release builds
+	Logger *slog.Logger
+
+	// ShutdownTimeout is the maximum time to wait for in-flight API calls and dialogs
+	// when the application shuts down. Default: 5s
+	ShutdownTimeout time.Duration
+
+	// APIMiddleware wraps the calls from JavaScript to the APIs of all windows. It runs
+	// before the APIMiddleware of the window, the first middleware is the outermost.
+	APIMiddleware []func(next CallHandler) CallHandler
 }
 
 type WebkitSettings struct {
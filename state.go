@@ -0,0 +1,143 @@
+package webkitgtk
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+type windowState struct {
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	X          int             `json:"x"` // X is relative to the monitor
+	Y          int             `json:"y"` // Y is relative to the monitor
+	Monitor    monitorGeometry `json:"monitor"`
+	Maximised  bool            `json:"maximised,omitempty"`
+	Fullscreen bool            `json:"fullscreen,omitempty"`
+}
+
+type monitorGeometry struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// statePath returns the path of the file the window state is stored in.
+func (w *Window) statePath() string {
+	return filepath.Join(w.app.dataDirectory(), "windows", url.PathEscape(w.options.Name)+".json")
+}
+
+// loadState returns the saved state of the window or nil if there is none or remembering
+// the state is disabled.
+func (w *Window) loadState() *windowState {
+	if !w.options.RememberState || w.options.Name == "" || w.app.ephemeral {
+		return nil
+	}
+	data, err := os.ReadFile(w.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			w.log.Warn("failed to read window state", "error", err)
+		}
+		return nil
+	}
+	var state windowState
+	if err := json.Unmarshal(data, &state); err != nil {
+		w.log.Warn("failed to decode window state", "error", err)
+		return nil
+	}
+	if state.Width <= 0 || state.Height <= 0 {
+		return nil
+	}
+	return &state
+}
+
+// saveState stores the current size, position and state of the window, must be called on the main thread.
+func (w *Window) saveState() {
+	if !w.options.RememberState || w.options.Name == "" || w.app.ephemeral {
+		return
+	}
+	state := w.loadState()
+	if state == nil {
+		state = &windowState{}
+	}
+	state.Maximised = w.IsMaximised()
+	state.Fullscreen = w.IsFullscreen()
+
+	// keep the last normal geometry while maximised or fullscreen
+	if (!state.Maximised && !state.Fullscreen) || state.Width == 0 {
+		x, y, width, height, _ := windowGetCurrentMonitorGeometry(w.pointer)
+		state.Monitor = monitorGeometry{X: x, Y: y, Width: width, Height: height}
+		state.Width, state.Height = w.GetSize()
+		state.X, state.Y = w.relativePosition()
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		w.log.Warn("failed to encode window state", "error", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(w.statePath()), 0755); err != nil {
+		w.log.Warn("failed to create window state directory", "error", err)
+		return
+	}
+	if err := os.WriteFile(w.statePath(), data, 0644); err != nil {
+		w.log.Warn("failed to write window state", "error", err)
+		return
+	}
+	w.log.Debug("window state saved", "path", w.statePath(), "state", state)
+}
+
+// restorePosition moves the window to the saved position. If the saved monitor is no
+// longer available the position is clamped to the current monitor.
+func (w *Window) restorePosition(state *windowState) {
+	monitor, found := state.Monitor, false
+	for _, geometry := range windowGetMonitorGeometries(w.pointer) {
+		if geometry == state.Monitor {
+			found = true
+			break
+		}
+	}
+	if !found {
+		x, y, width, height, _ := windowGetCurrentMonitorGeometry(w.pointer)
+		if x == -1 && y == -1 && width == -1 && height == -1 {
+			return
+		}
+		monitor = monitorGeometry{X: x, Y: y, Width: width, Height: height}
+		w.log.Debug("saved monitor not found, clamping position", "monitor", monitor)
+	}
+	width, height := w.GetSize()
+	x := max(min(state.X, monitor.Width-width), 0)
+	y := max(min(state.Y, monitor.Height-height), 0)
+	windowMove(w.pointer, monitor.X+x, monitor.Y+y)
+}
+
+func windowGetMonitorGeometries(window windowPtr) []monitorGeometry {
+	display := lib.gtk.WidgetGetDisplay(window)
+	if display == 0 {
+		return nil
+	}
+	var geometries []monitorGeometry
+	for i := 0; i < lib.gdk.DisplayGetNMonitors(display); i++ {
+		monitor := lib.gdk.DisplayGetMonitor(display, i)
+		if monitor == 0 {
+			continue
+		}
+		result := struct {
+			x      int32
+			y      int32
+			width  int32
+			height int32
+		}{}
+		lib.gdk.MonitorGetGeometry(monitor, ptr(unsafe.Pointer(&result)))
+		geometries = append(geometries, monitorGeometry{
+			X:      int(result.x),
+			Y:      int(result.y),
+			Width:  int(result.width),
+			Height: int(result.height),
+		})
+	}
+	return geometries
+}
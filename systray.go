@@ -3,11 +3,11 @@ package webkitgtk
 import (
 	_ "embed"
 	"fmt"
-	"github.com/ebitengine/purego"
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
 	"github.com/godbus/dbus/v5/prop"
 	"image"
+	"log/slog"
 	"os"
 	"sync"
 )
@@ -18,7 +18,8 @@ const (
 )
 
 type dbusSystray struct {
-	log         logFunc
+	log         *slog.Logger
+	thread      *mainThread
 	label       string
 	icon        []byte
 	onOpen      func()
@@ -70,6 +71,7 @@ func (s *dbusSystray) processMenu(menu *TrayMenu, parentItem *MenuItem) {
 			item.dbusItem.V1["toggle-state"] = v
 		case submenu:
 			item.dbusItem.V1["children-display"] = dbus.MakeVariant("submenu")
+			item.submenu.tray = s
 			s.processMenu(item.submenu, item)
 		case text:
 		case radio:
@@ -91,7 +93,7 @@ func (s *dbusSystray) refresh() {
 	s.menuVersion++
 	if err := s.menuProps.Set("com.canonical.dbusmenu", "Version",
 		dbus.MakeVariant(s.menuVersion)); err != nil {
-		fmt.Errorf("systray error: failed to update menu version: %v", err)
+		s.log.Error("failed to update menu version", "error", err)
 		return
 	}
 	if err := dbusEmit(s.conn, &dbusMenuLayoutUpdatedSignal{
@@ -100,7 +102,7 @@ func (s *dbusSystray) refresh() {
 			Revision: s.menuVersion,
 		},
 	}); err != nil {
-		fmt.Errorf("systray error: failed to emit layout updated signal: %v", err)
+		s.log.Error("failed to emit layout updated signal", "error", err)
 	}
 }
 
@@ -117,7 +119,7 @@ func (s *dbusSystray) Start(conn *dbus.Conn) error {
 	name := fmt.Sprintf("org.kde.StatusNotifierItem-%d-1", os.Getpid()) // register id 1 for this process
 	_, err = conn.RequestName(name, dbus.NameFlagDoNotQueue)
 	if err != nil {
-		fmt.Errorf("systray error: failed to request name: %s\n", err)
+		s.log.Error("failed to request name", "name", name, "error", err)
 	}
 	props, err := prop.Export(conn, dbusTrayItemPath, s.createPropSpec())
 	if err != nil {
@@ -185,7 +187,7 @@ func (s *dbusSystray) Start(conn *dbus.Conn) error {
 	s.processMenu(s.menu, rootItem)
 	s.refresh()
 
-	s.log("started")
+	s.log.Info("started")
 	return nil
 }
 
@@ -200,7 +202,7 @@ func (s *dbusSystray) Signal(sig *dbus.Signal) {
 }
 
 func (s *dbusSystray) Stop() {
-	s.log("stopped")
+	s.log.Info("stopped")
 }
 
 func (s *dbusSystray) createMenuPropSpec() map[string]map[string]*prop.Prop {
@@ -312,7 +314,7 @@ func (s *dbusSystray) register() bool {
 	obj := s.conn.Object("org.kde.StatusNotifierWatcher", "/StatusNotifierWatcher")
 	call := obj.Call("org.kde.StatusNotifierWatcher.RegisterStatusNotifierItem", 0, dbusTrayItemPath)
 	if call.Err != nil {
-		s.log("systray error: failed to register", "error", call.Err)
+		s.log.Error("failed to register", "error", call.Err)
 		return false
 	}
 
@@ -331,20 +333,20 @@ func (s *dbusSystray) AboutToShowGroup(ids []int32) (updatesNeeded []int32, idEr
 
 // GetProperty is an implementation of the com.canonical.dbusmenu.GetProperty method.
 func (s *dbusSystray) GetProperty(id int32, name string) (value dbus.Variant, err *dbus.Error) {
-	if item, ok := s.getMenuItem(id); ok {
-		if p, ok := item.dbusItem.V1[name]; ok {
-			return p, nil
+	s.thread.InvokeSync(func() {
+		if item, ok := s.getMenuItem(id); ok {
+			value = item.dbusItem.V1[name]
 		}
-	}
-	return
+	})
+	return value, nil
 }
 
 // Event is com.canonical.dbusmenu.Event method.
 func (s *dbusSystray) Event(id int32, eventID string, data dbus.Variant, timestamp uint32) (err *dbus.Error) {
-	s.log("event", "id", id, "eventID", eventID, "data", data, "timestamp", timestamp)
+	s.log.Debug("event", "id", id, "eventID", eventID, "data", data, "timestamp", timestamp)
 	if eventID == "clicked" {
 		if item, ok := s.getMenuItem(id); ok {
-			go item.handleClick()
+			s.thread.InvokeAsync(item.handleClick)
 		}
 	}
 	return
@@ -357,14 +359,16 @@ func (s *dbusSystray) EventGroup(events []struct {
 	V2 dbus.Variant
 	V3 uint32
 }) (idErrors []int32, err *dbus.Error) {
-	for _, event := range events {
-		if event.V1 == "clicked" {
-			item, ok := s.getMenuItem(event.V0)
-			if ok {
-				item.handleClick()
+	s.thread.InvokeSync(func() {
+		for _, event := range events {
+			if event.V1 == "clicked" {
+				item, ok := s.getMenuItem(event.V0)
+				if ok {
+					item.handleClick()
+				}
 			}
 		}
-	}
+	})
 	return
 }
 
@@ -373,73 +377,110 @@ func (s *dbusSystray) GetGroupProperties(ids []int32, propertyNames []string) (p
 	V0 int32
 	V1 map[string]dbus.Variant
 }, err *dbus.Error) {
-	for _, id := range ids {
-		if m, ok := s.getMenuItem(id); ok {
-			p := struct {
-				V0 int32
-				V1 map[string]dbus.Variant
-			}{
-				V0: m.dbusItem.V0,
-				V1: make(map[string]dbus.Variant, len(m.dbusItem.V1)),
-			}
-			for k, v := range m.dbusItem.V1 {
-				p.V1[k] = v
+	s.thread.InvokeSync(func() {
+		for _, id := range ids {
+			if m, ok := s.getMenuItem(id); ok {
+				p := struct {
+					V0 int32
+					V1 map[string]dbus.Variant
+				}{
+					V0: m.dbusItem.V0,
+					V1: make(map[string]dbus.Variant, len(m.dbusItem.V1)),
+				}
+				for k, v := range m.dbusItem.V1 {
+					p.V1[k] = v
+				}
+				properties = append(properties, p)
 			}
-			properties = append(properties, p)
 		}
-	}
+	})
 	return properties, nil
 }
 
 // GetLayout is an implementation of the com.canonical.dbusmenu.GetLayout method.
 func (s *dbusSystray) GetLayout(parentID int32, recursionDepth int32, propertyNames []string) (revision uint32, layout dbusItem, err *dbus.Error) {
-	if m, ok := s.getMenuItem(parentID); ok {
-		return s.menuVersion, *m.dbusItem, nil
+	s.thread.InvokeSync(func() {
+		if m, ok := s.getMenuItem(parentID); ok {
+			revision, layout = s.menuVersion, dbusItemCopy(m.dbusItem)
+		}
+	})
+	return revision, layout, nil
+}
+
+// dbusItemCopy returns a deep copy of the item so it can be encoded outside the main thread.
+func dbusItemCopy(item *dbusItem) dbusItem {
+	result := dbusItem{
+		V0: item.V0,
+		V1: make(map[string]dbus.Variant, len(item.V1)),
+		V2: make([]dbus.Variant, 0, len(item.V2)),
 	}
-	return
+	for k, v := range item.V1 {
+		result.V1[k] = v
+	}
+	for _, v := range item.V2 {
+		if child, ok := v.Value().(*dbusItem); ok {
+			child := dbusItemCopy(child)
+			v = dbus.MakeVariant(&child)
+		}
+		result.V2 = append(result.V2, v)
+	}
+	return result
 }
 
 // Activate implements org.kde.StatusNotifierItem.Activate method.
 func (s *dbusSystray) Activate(x int32, y int32) (err *dbus.Error) {
-	s.log("Activate", x, y)
+	s.log.Debug("activate", "x", x, "y", y)
 	return
 }
 
 // ContextMenu is org.kde.StatusNotifierItem.ContextMenu method
 func (s *dbusSystray) ContextMenu(x int32, y int32) (err *dbus.Error) {
-	s.log("ContextMenu", x, y)
+	s.log.Debug("context menu", "x", x, "y", y)
 	return
 }
 
 func (s *dbusSystray) Scroll(delta int32, orientation string) (err *dbus.Error) {
-	s.log("Scroll", delta, orientation)
+	s.log.Debug("scroll", "delta", delta, "orientation", orientation)
 	return
 }
 
 // SecondaryActivate implements org.kde.StatusNotifierItem.SecondaryActivate method.
 func (s *dbusSystray) SecondaryActivate(x int32, y int32) (err *dbus.Error) {
-	s.log("SecondaryActivate", x, y)
+	s.log.Debug("secondary activate", "x", x, "y", y)
 	return
 }
 
 type TrayMenu struct {
+	tray   *dbusSystray
 	item   *MenuItem
 	items  []*MenuItem
 	label  string
 	native ptr
 }
 
-func (m *TrayMenu) toTray(label string, icon []byte) *dbusSystray {
+func (m *TrayMenu) toTray(label string, icon []byte, thread *mainThread, log *slog.Logger) *dbusSystray {
 	if icon == nil {
 		icon = defaultIcon
 	}
-	return &dbusSystray{
-		log:         newLogFunc("dbus-systray"),
+	m.tray = &dbusSystray{
+		log:         log,
+		thread:      thread,
 		menu:        m,
 		label:       label,
 		icon:        icon,
 		menuVersion: 1,
 	}
+	return m.tray
+}
+
+// invoke runs fn on the main thread once the menu is attached to a running tray,
+// otherwise fn is run directly.
+func (m *TrayMenu) invoke(fn func()) {
+	if m.tray == nil {
+		fn()
+		return
+	}
+	m.tray.thread.InvokeSync(fn)
 }
 
 func (m *TrayMenu) Add(label string) *MenuItem {
@@ -479,12 +520,14 @@ func (m *TrayMenu) AddRadio(label string, checked bool) *MenuItem {
 }
 
 func (m *TrayMenu) Update() {
-	m.processRadioGroups()
+	m.invoke(func() {
+		m.processRadioGroups()
 
-	if m.native == 0 {
-		m.native = lib.gtk.MenuNew()
-	}
-	m.update()
+		if m.native == 0 {
+			m.native = lib.gtk.MenuNew()
+		}
+		m.update()
+	})
 }
 
 func (m *TrayMenu) AddSubmenu(label string) *TrayMenu {
@@ -591,12 +634,11 @@ func processMenu(m *TrayMenu) {
 					}
 				}
 			}
-			item.handlerId = lib.g.SignalConnectObject(
+			item.handlerId = signalConnect(
 				item.native,
 				"activate",
-				ptr(purego.NewCallback(handler)),
-				item.native,
-				0)
+				callbackFunc1(),
+				func(ptr) { handler() })
 		}
 	}
 }
@@ -669,51 +711,75 @@ func (item *MenuItem) handleClick() {
 		go item.callback(item.checked)
 	}
 }
-func (item *MenuItem) SetLabel(label string) *MenuItem {
-	item.label = label
-	if item.dbusItem != nil {
-		item.dbusItem.V1["label"] = dbus.MakeVariant(item.label)
-		item.tray.refresh()
+
+// invoke runs fn on the main thread once the item is attached to a running tray,
+// otherwise fn is run directly.
+func (item *MenuItem) invoke(fn func()) {
+	if item.tray == nil {
+		fn()
+		return
 	}
+	item.tray.thread.InvokeSync(fn)
+}
+
+func (item *MenuItem) SetLabel(label string) *MenuItem {
+	item.invoke(func() {
+		item.label = label
+		if item.dbusItem != nil {
+			item.dbusItem.V1["label"] = dbus.MakeVariant(item.label)
+			item.tray.refresh()
+		}
+	})
 	return item
 }
 
 func (item *MenuItem) SetDisabled(disabled bool) *MenuItem {
-	item.disabled = disabled
-	if item.dbusItem != nil {
-		item.setDisabled(item.disabled)
-	}
+	item.invoke(func() {
+		item.disabled = disabled
+		if item.dbusItem != nil {
+			item.setDisabled(item.disabled)
+		}
+	})
 	return item
 }
 
 func (item *MenuItem) SetIcon(icon []byte) *MenuItem {
-	item.icon = icon
-	if item.dbusItem != nil {
-		item.dbusItem.V1["icon-data"] = dbus.MakeVariant(item.icon)
-		item.tray.refresh()
-	}
+	item.invoke(func() {
+		item.icon = icon
+		if item.dbusItem != nil {
+			item.dbusItem.V1["icon-data"] = dbus.MakeVariant(item.icon)
+			item.tray.refresh()
+		}
+	})
 	return item
 }
 
 func (item *MenuItem) SetChecked(checked bool) *MenuItem {
-	item.checked = checked
-	if item.dbusItem != nil {
-		item.setChecked(item.checked)
-	}
+	item.invoke(func() {
+		item.checked = checked
+		if item.dbusItem != nil {
+			item.setChecked(item.checked)
+		}
+	})
 	return item
 }
 
 func (item *MenuItem) SetHidden(hidden bool) *MenuItem {
-	item.hidden = hidden
-	if item.dbusItem != nil {
-		item.dbusItem.V1["visible"] = dbus.MakeVariant(!item.hidden)
-		item.tray.refresh()
-	}
+	item.invoke(func() {
+		item.hidden = hidden
+		if item.dbusItem != nil {
+			item.dbusItem.V1["visible"] = dbus.MakeVariant(!item.hidden)
+			item.tray.refresh()
+		}
+	})
 	return item
 }
 
-func (item *MenuItem) Checked() bool {
-	return item.checked
+func (item *MenuItem) Checked() (checked bool) {
+	item.invoke(func() {
+		checked = item.checked
+	})
+	return checked
 }
 
 func (item *MenuItem) IsSeparator() bool {
@@ -732,21 +798,32 @@ func (item *MenuItem) IsRadio() bool {
 	return item.itemType == radio
 }
 
-func (item *MenuItem) Hidden() bool {
-	return item.hidden
+func (item *MenuItem) Hidden() (hidden bool) {
+	item.invoke(func() {
+		hidden = item.hidden
+	})
+	return hidden
 }
 
 func (item *MenuItem) OnClick(f func(bool)) *MenuItem {
-	item.callback = f
+	item.invoke(func() {
+		item.callback = f
+	})
 	return item
 }
 
-func (item *MenuItem) Label() string {
-	return item.label
+func (item *MenuItem) Label() (label string) {
+	item.invoke(func() {
+		label = item.label
+	})
+	return label
 }
 
-func (item *MenuItem) Enabled() bool {
-	return !item.disabled
+func (item *MenuItem) Enabled() (enabled bool) {
+	item.invoke(func() {
+		enabled = !item.disabled
+	})
+	return enabled
 }
 
 func (item *MenuItem) setDisabled(disabled bool) {
@@ -0,0 +1,35 @@
+package webkitgtk
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMenuItemAccessorsConcurrent(t *testing.T) {
+	l := newTestLoop(t)
+
+	tray := &dbusSystray{thread: l.mt, itemMap: make(map[int32]*MenuItem)}
+	item := &MenuItem{itemType: checkbox}
+	tray.setMenuItem(item)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				item.SetLabel("item").Label()
+				item.SetChecked(i%2 == 0).Checked()
+				item.SetDisabled(i%2 == 1).Enabled()
+				item.SetHidden(i%2 == 1).Hidden()
+				item.SetIcon(nil)
+				item.OnClick(func(bool) {})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if label := item.Label(); label != "item" {
+		t.Fatalf("label is %q, want %q", label, "item")
+	}
+}
@@ -0,0 +1,128 @@
+package webkitgtk
+
+import (
+	"sync"
+	"time"
+)
+
+// Timer is a timer of the GTK main loop created by App.AfterFunc, App.Ticker or App.Idle.
+// Its function always runs on the main thread. All timers are stopped when the app shuts down.
+type Timer struct {
+	app      *App
+	mutex    sync.Mutex
+	fn       func() bool   // fn is the timer function, it reports if the timer continues
+	interval time.Duration // interval of the timer, negative for idle callbacks
+	source   uint          // source is the id of the GLib source, 0 if not attached
+	stopped  bool
+}
+
+// AfterFunc calls fn on the main thread once the duration elapsed.
+func (a *App) AfterFunc(d time.Duration, fn func()) *Timer {
+	return a.addTimer(max(d, 0), func() bool {
+		fn()
+		return false
+	})
+}
+
+// Ticker calls fn on the main thread every time the duration elapsed until the timer is stopped.
+func (a *App) Ticker(d time.Duration, fn func()) *Timer {
+	return a.addTimer(max(d, time.Millisecond), func() bool {
+		fn()
+		return true
+	})
+}
+
+// Idle calls fn on the main thread whenever there are no events of higher priority pending,
+// until fn returns false or the timer is stopped.
+func (a *App) Idle(fn func() bool) *Timer {
+	return a.addTimer(-1, fn)
+}
+
+// Stop prevents the timer from firing again. It reports false if the timer already
+// stopped or, for timers created by AfterFunc, already fired.
+func (t *Timer) Stop() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	if t.source != 0 {
+		lib.g.SourceRemove(t.source)
+		t.source = 0
+	}
+	t.app.removeTimer(t)
+	return true
+}
+
+func (a *App) addTimer(interval time.Duration, fn func() bool) *Timer {
+	t := &Timer{
+		app:      a,
+		fn:       fn,
+		interval: interval,
+	}
+	a.timersLock.Lock()
+	a.timers[t] = struct{}{}
+	a.timersLock.Unlock()
+	a.Dispatch(t.start)
+	return t
+}
+
+func (a *App) removeTimer(t *Timer) {
+	a.timersLock.Lock()
+	defer a.timersLock.Unlock()
+	delete(a.timers, t)
+}
+
+// stopTimers stops all timers, must be called on the main thread.
+func (a *App) stopTimers() {
+	a.timersLock.Lock()
+	timers := make([]*Timer, 0, len(a.timers))
+	for t := range a.timers {
+		timers = append(timers, t)
+	}
+	a.timersLock.Unlock()
+	for _, t := range timers {
+		t.Stop()
+	}
+	if len(timers) > 0 {
+		a.log.Debug("timers stopped", "count", len(timers))
+	}
+}
+
+// start attaches the timer to the main loop, must be called on the main thread.
+func (t *Timer) start() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return
+	}
+	id := callbacks.register(t.tick)
+	if t.interval < 0 {
+		t.source = lib.g.IdleAddFull(gPriorityDefaultIdle, callbackSource(), id, callbackDestroy())
+	} else {
+		t.source = lib.g.TimeoutAddFull(gPriorityDefault, uint(t.interval.Milliseconds()), callbackSource(), id, callbackDestroy())
+	}
+}
+
+func (t *Timer) tick() int {
+	t.mutex.Lock()
+	stopped := t.stopped
+	t.mutex.Unlock()
+	if stopped || !t.run() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if !t.stopped {
+			t.stopped = true
+			t.app.removeTimer(t)
+		}
+		t.source = 0
+		return gSourceRemove
+	}
+	return gSourceContinue
+}
+
+func (t *Timer) run() bool {
+	defer panicHandlerRecover()
+	return t.fn()
+}
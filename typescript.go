@@ -0,0 +1,339 @@
+package webkitgtk
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	tsTimeType          = reflect.TypeOf(time.Time{})
+	tsRawMessageType    = reflect.TypeOf(json.RawMessage{})
+	tsJSONMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	tsTextMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// TypeScript returns TypeScript declarations for the bindings and constants of a
+// WindowOptions.Define map. Go types are described by the shape of their JSON encoding,
+// so slices, maps and pointers include null since they encode as null when nil. Fields
+// omitted when empty are optional instead.
+func TypeScript(define map[string]interface{}) (string, error) {
+	g := &tsGenerator{
+		names:      make(map[reflect.Type]string),
+		types:      make(map[string]reflect.Type),
+		interfaces: make(map[string]string),
+	}
+
+	names := make([]string, 0, len(define))
+	for name := range define {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var namespaces, constants strings.Builder
+	for _, name := range names {
+		v := define[name]
+		if apiBindable(v) {
+			binding, err := apiBind(v)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", name, err)
+			}
+			if m, ok := binding[""]; ok {
+				namespaces.WriteString("declare function " + name + "(" + g.params(m) + "): " + g.result(m) + ";\n\n")
+			} else {
+				namespaces.WriteString(g.namespace(name, binding))
+			}
+		} else {
+			constants.WriteString("declare const " + name + ": " + g.typeOf(reflect.TypeOf(v)) + ";\n")
+		}
+	}
+
+	var s strings.Builder
+	s.WriteString("// Code generated by webkitgtk. DO NOT EDIT.\n\n")
+	interfaces := make([]string, 0, len(g.interfaces))
+	for name := range g.interfaces {
+		interfaces = append(interfaces, name)
+	}
+	sort.Strings(interfaces)
+	for _, name := range interfaces {
+		s.WriteString(g.interfaces[name])
+		s.WriteString("\n")
+	}
+	s.WriteString(namespaces.String())
+	if constants.Len() > 0 {
+		s.WriteString(constants.String())
+		s.WriteString("\n")
+	}
+	s.WriteString(tsWebkitAPI)
+	return s.String(), nil
+}
+
+// WriteTypeScript writes the TypeScript declarations of the Define map to the file at path.
+func WriteTypeScript(path string, define map[string]interface{}) error {
+	ts, err := TypeScript(define)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(ts), 0644)
+}
+
+const tsWebkitAPI = `interface WebkitAPIError extends Error {
+	code?: string;
+	data?: any;
+}
+
+declare const webkitAPI: {
+	on(name: string, fn: (data: any) => void): () => void;
+	off(name: string, fn?: (data: any) => void): void;
+	once(name: string, fn: (data: any) => void): () => void;
+};
+`
+
+type tsGenerator struct {
+	names      map[reflect.Type]string // names are the interface names of named struct types
+	types      map[string]reflect.Type // types are the struct types by interface name
+	interfaces map[string]string       // interfaces are the declarations by interface name
+}
+
+func (g *tsGenerator) namespace(name string, binding apiBinding) string {
+	var s strings.Builder
+	g.writeNamespace(&s, "declare namespace "+name, binding, "")
+	s.WriteString("\n")
+	return s.String()
+}
+
+// writeNamespace writes the functions of the binding followed by the nested namespaces
+// of functions with a dotted name.
+func (g *tsGenerator) writeNamespace(s *strings.Builder, decl string, binding apiBinding, indent string) {
+	fns := make([]string, 0, len(binding))
+	nested := make(map[string]apiBinding)
+	for fn, m := range binding {
+		if ns, rest, ok := strings.Cut(fn, "."); ok {
+			if nested[ns] == nil {
+				nested[ns] = make(apiBinding)
+			}
+			nested[ns][rest] = m
+		} else {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Strings(fns)
+	namespaces := make([]string, 0, len(nested))
+	for ns := range nested {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	s.WriteString(indent + decl + " {\n")
+	for _, fn := range fns {
+		s.WriteString(indent + "\tfunction " + fn + "(" + g.params(binding[fn]) + "): " + g.result(binding[fn]) + ";\n")
+	}
+	for _, ns := range namespaces {
+		g.writeNamespace(s, "namespace "+ns, nested[ns], indent+"\t")
+	}
+	s.WriteString(indent + "}\n")
+}
+
+func (g *tsGenerator) params(m *apiMethod) string {
+	t := m.value.Type()
+	first := 0
+	if m.hasContext {
+		first = 1
+	}
+	var params []string
+	for i := first; i < t.NumIn(); i++ {
+		name := "arg" + strconv.Itoa(i-first)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			params = append(params, "..."+name+": "+tsArray(g.param(t.In(i).Elem())))
+		} else {
+			params = append(params, name+": "+g.param(t.In(i)))
+		}
+	}
+	if m.hasContext && !t.IsVariadic() && !m.stream {
+		params = append(params, "signal?: AbortSignal")
+	}
+	return strings.Join(params, ", ")
+}
+
+func (g *tsGenerator) result(m *apiMethod) string {
+	if !m.hasOutput {
+		return "Promise<void>"
+	}
+	output := m.value.Type().Out(0)
+	if m.stream {
+		return "AsyncIterable<" + g.typeOf(output.Elem()) + ">"
+	}
+	switch {
+	case output == apiBytesType:
+		return "Promise<ArrayBuffer>"
+	case output.Implements(apiReaderType):
+		return "Promise<Blob | null>"
+	}
+	return "Promise<" + g.typeOf(output) + ">"
+}
+
+// param returns the TypeScript type of a parameter, binary parameters are uploaded as blobs.
+func (g *tsGenerator) param(t reflect.Type) string {
+	if t == apiBytesType || t == apiReaderType {
+		return "ArrayBuffer | ArrayBufferView | Blob | string"
+	}
+	return g.typeOf(t)
+}
+
+// typeOf returns the TypeScript type of the JSON encoding of t.
+func (g *tsGenerator) typeOf(t reflect.Type) string {
+	if t == nil {
+		return "null"
+	}
+	switch {
+	case t == tsTimeType:
+		return "string"
+	case t == tsRawMessageType:
+		return "any"
+	case t.Implements(tsJSONMarshalerType) || reflect.PointerTo(t).Implements(tsJSONMarshalerType):
+		return "any"
+	case t.Implements(tsTextMarshalerType) || reflect.PointerTo(t).Implements(tsTextMarshalerType):
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string | null" // base64
+		}
+		return tsArray(g.typeOf(t.Elem())) + " | null"
+	case reflect.Array:
+		return tsArray(g.typeOf(t.Elem()))
+	case reflect.Map:
+		switch t.Key().Kind() {
+		case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return "Record<string, " + g.typeOf(t.Elem()) + "> | null"
+		}
+		if t.Key().Implements(tsTextMarshalerType) {
+			return "Record<string, " + g.typeOf(t.Elem()) + "> | null"
+		}
+		return "any"
+	case reflect.Ptr:
+		return g.typeOf(t.Elem()) + " | null"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return "{ " + strings.Join(g.fields(t), "; ") + " }"
+		}
+		return g.named(t)
+	}
+	return "any"
+}
+
+// named returns the interface name of the struct type and declares it on first use.
+func (g *tsGenerator) named(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := tsIdentifier(t.Name())
+	if other, ok := g.types[name]; ok && other != t {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = tsIdentifier(strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name())
+		for i := 2; g.types[name] != nil; i++ {
+			name = tsIdentifier(t.Name()) + strconv.Itoa(i)
+		}
+	}
+	g.names[t] = name
+	g.types[name] = t
+	var s strings.Builder
+	s.WriteString("interface " + name + " {\n")
+	for _, field := range g.fields(t) {
+		s.WriteString("\t" + field + ";\n")
+	}
+	s.WriteString("}\n")
+	g.interfaces[name] = s.String()
+	return name
+}
+
+// fields returns the properties of the struct as encoded by encoding/json.
+func (g *tsGenerator) fields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if field.Anonymous {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if name == "" && fieldType.Kind() == reflect.Struct {
+				fields = append(fields, g.fields(fieldType)...) // embedded fields are promoted
+				continue
+			}
+			if !field.IsExported() && fieldType.Kind() != reflect.Struct {
+				continue
+			}
+		} else if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		optional := ""
+		typ := g.typeOf(field.Type)
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty", "omitzero":
+				optional = "?"
+				typ = strings.TrimSuffix(typ, " | null") // nil is omitted
+			case "string":
+				switch field.Type.Kind() {
+				case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+					reflect.Float32, reflect.Float64, reflect.String:
+					typ = "string"
+				}
+			}
+		}
+		fields = append(fields, tsPropertyName(name)+optional+": "+typ)
+	}
+	return fields
+}
+
+func tsArray(elem string) string {
+	if strings.Contains(elem, " | ") {
+		return "(" + elem + ")[]"
+	}
+	return elem + "[]"
+}
+
+// tsIdentifier replaces characters not allowed in identifiers, e.g. of generic type names.
+func tsIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// tsPropertyName quotes the property name if it is not a valid identifier.
+func tsPropertyName(s string) string {
+	if s != "" && s == tsIdentifier(s) && !unicode.IsDigit([]rune(s)[0]) {
+		return s
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,197 @@
+package webkitgtk
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type testTSItem struct {
+	ID       int               `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	Count    int64             `json:"count,string"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Parent   *testTSItem       `json:"parent,omitempty"`
+	Created  time.Time         `json:"created"`
+	Skipped  string            `json:"-"`
+	Untagged bool
+	internal int
+}
+
+type testTSBase struct {
+	ID int `json:"id"`
+}
+
+type testTSAudit struct {
+	Author string `json:"author"`
+}
+
+type testTSEmbedding struct {
+	testTSBase
+	*testTSAudit
+	Base  testTSBase `json:"base"`
+	Title string     `json:"title"`
+}
+
+type testTSStore struct{}
+
+func (*testTSStore) Get(ctx context.Context, id int) (*testTSBase, error)     { return nil, nil }
+func (*testTSStore) Find(ctx context.Context, q string) ([]testTSBase, error) { return nil, nil }
+func (*testTSStore) Tag(id int, tags ...string) error                         { return nil }
+func (*testTSStore) Watch(ctx context.Context) (<-chan testTSBase, error)     { return nil, nil }
+
+func TestTypeScript(t *testing.T) {
+	// types of the same name declared in different scopes collide
+	type testTSValue struct {
+		A int `json:"a"`
+	}
+	first := testTSValue{}
+	var second, third interface{}
+	{
+		type testTSValue struct {
+			B int `json:"b"`
+		}
+		second = testTSValue{}
+	}
+	{
+		type testTSValue struct {
+			C int `json:"c"`
+		}
+		third = testTSValue{}
+	}
+
+	tests := []struct {
+		name   string
+		define map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "json tags",
+			define: map[string]interface{}{"item": testTSItem{}},
+			want: `interface testTSItem {
+	id: number;
+	name?: string;
+	count: string;
+	tags: string[] | null;
+	labels?: Record<string, string>;
+	parent?: testTSItem;
+	created: string;
+	Untagged: boolean;
+}
+
+declare const item: testTSItem;
+
+`,
+		},
+		{
+			name:   "embedded",
+			define: map[string]interface{}{"embedding": testTSEmbedding{}},
+			want: `interface testTSBase {
+	id: number;
+}
+
+interface testTSEmbedding {
+	id: number;
+	author: string;
+	base: testTSBase;
+	title: string;
+}
+
+declare const embedding: testTSEmbedding;
+
+`,
+		},
+		{
+			name:   "name collision",
+			define: map[string]interface{}{"a": first, "b": second, "c": third},
+			want: `interface WebkitgtktestTSValue {
+	b: number;
+}
+
+interface testTSValue {
+	a: number;
+}
+
+interface testTSValue2 {
+	c: number;
+}
+
+declare const a: testTSValue;
+declare const b: WebkitgtktestTSValue;
+declare const c: testTSValue2;
+
+`,
+		},
+		{
+			name: "nil values",
+			define: map[string]interface{}{
+				"list":  []testTSBase{},
+				"table": map[string][]int{},
+				"ptr":   (*testTSBase)(nil),
+			},
+			want: `interface testTSBase {
+	id: number;
+}
+
+declare const list: testTSBase[] | null;
+declare const ptr: testTSBase | null;
+declare const table: Record<string, number[] | null> | null;
+
+`,
+		},
+		{
+			name:   "methods",
+			define: map[string]interface{}{"store": &testTSStore{}},
+			want: `interface testTSBase {
+	id: number;
+}
+
+declare namespace store {
+	function find(arg0: string, signal?: AbortSignal): Promise<testTSBase[] | null>;
+	function get(arg0: number, signal?: AbortSignal): Promise<testTSBase | null>;
+	function tag(arg0: number, ...arg1: string[]): Promise<void>;
+	function watch(): AsyncIterable<testTSBase>;
+}
+
+`,
+		},
+		{
+			name: "variadic",
+			define: map[string]interface{}{
+				"sum":  func(nums ...int) (int, error) { return 0, nil },
+				"join": func(ctx context.Context, sep string, parts ...[]string) (string, error) { return "", nil },
+			},
+			want: `declare function join(arg0: string, ...arg1: (string[] | null)[]): Promise<string>;
+
+declare function sum(...arg0: number[]): Promise<number>;
+
+`,
+		},
+		{
+			name: "blobs",
+			define: map[string]interface{}{
+				"upload":   func(data []byte, r io.Reader) ([]byte, error) { return nil, nil },
+				"download": func(ctx context.Context, name string) (io.Reader, error) { return nil, nil },
+			},
+			want: `declare function download(arg0: string, signal?: AbortSignal): Promise<Blob | null>;
+
+declare function upload(arg0: ArrayBuffer | ArrayBufferView | Blob | string, arg1: ArrayBuffer | ArrayBufferView | Blob | string): Promise<ArrayBuffer>;
+
+`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := TypeScript(test.define)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "// Code generated by webkitgtk. DO NOT EDIT.\n\n" + test.want + tsWebkitAPI; got != want {
+				t.Fatalf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -2,16 +2,15 @@ package webkitgtk
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
-	"fmt"
-	"github.com/ebitengine/purego"
 	"image"
 	"image/draw"
 	"image/png"
+	"log/slog"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
 var _RELEASE = false
@@ -97,33 +96,26 @@ const (
 	CacheFull
 )
 
-var LogWriter = os.Stderr
-
-type logFunc func(msg interface{}, keyvals ...interface{})
-
-func newLogFunc(prefix string) logFunc {
-	return func(msg interface{}, keyvals ...interface{}) {
-		if LogWriter == nil {
-			return
-		}
-		var s strings.Builder
-		s.WriteString(prefix)
-		s.WriteString(": ")
-		s.WriteString(fmt.Sprintf("%v", msg))
-		for i := 0; i < len(keyvals); i += 2 {
-			s.WriteString(" ")
-			s.WriteString(fmt.Sprintf("%v", keyvals[i]))
-			s.WriteString("=")
-			s.WriteString(fmt.Sprintf("%v", keyvals[i+1]))
-		}
-		s.WriteString("\n")
-		_, _ = LogWriter.Write([]byte(s.String()))
+// defaultLogger returns the logger used if no logger is set in the AppOptions. It writes
+// debug logs to stderr while release builds discard all logs.
+func defaultLogger() *slog.Logger {
+	if _RELEASE {
+		return slog.New(discardHandler{})
 	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
 }
 
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
 type deferredRunner struct {
 	mutex     sync.Mutex
 	running   bool
+	stopped   bool // stopped is set from the shutdown until the application is run again
 	runnables []runnable
 }
 
@@ -131,137 +123,117 @@ type runnable interface {
 	run()
 }
 
+// run runs the runnable if the application is started or defers it until it is. The
+// runnable is run outside the lock, so it may dispatch to the runner itself.
 func (r *deferredRunner) run(runnable runnable) {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	if r.running {
-		runnable.run()
-	} else {
+	if !r.running {
 		r.runnables = append(r.runnables, runnable)
+		r.mutex.Unlock()
+		return
 	}
+	r.mutex.Unlock()
+	runnable.run()
 }
 
-func (r *deferredRunner) invoke() {
+// runStarted runs the runnable like run, but reports false instead of deferring the
+// runnable if the application stopped.
+func (r *deferredRunner) runStarted(runnable runnable) bool {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	r.running = true
-	for _, runnable := range r.runnables {
-		go runnable.run()
-	}
-	r.runnables = nil
-}
-
-type mainThread struct {
-	sync.Mutex
-	id    uint64
-	fnMap map[uint16]func()
-}
-
-func newMainThread() *mainThread {
-	return &mainThread{
-		id:    lib.g.ThreadSelf(),
-		fnMap: make(map[uint16]func()),
+	switch {
+	case r.stopped:
+		r.mutex.Unlock()
+		return false
+	case !r.running:
+		r.runnables = append(r.runnables, runnable)
+		r.mutex.Unlock()
+		return true
 	}
+	r.mutex.Unlock()
+	runnable.run()
+	return true
 }
 
-func (mt *mainThread) ID() uint64 {
-	return mt.id
-
+// reset marks the application as stopped once it shut down.
+func (r *deferredRunner) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.running = false
+	r.stopped = true
 }
 
-func (mt *mainThread) Running() bool {
-	return mt.id == lib.g.ThreadSelf()
+// prepare clears the stopped state when the application is run again.
+func (r *deferredRunner) prepare() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopped = false
 }
 
-func (mt *mainThread) register(fn func()) uint16 {
-	mt.Lock()
-	defer mt.Unlock()
-
-	var id uint16
-	for {
-		_, exist := mt.fnMap[id]
-		if !exist {
-			mt.fnMap[id] = fn
-			return id
-		}
-		id++
-		if id == 0 {
-			panic("FATAL: Too many functions have been dispatched to the main thread")
-			os.Exit(1)
+// invoke runs the deferred runnables once the application started. Dispatched
+// functions only enqueue and are run in order under the lock, so they keep their order
+// among each other and before later dispatches. Windows and dialogs block until they
+// are done and are run on their own goroutine.
+func (r *deferredRunner) invoke() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.running = true
+	for _, runnable := range r.runnables {
+		if dispatch, ok := runnable.(runnableFunc); ok {
+			dispatch.run()
+			continue
 		}
+		go runnable.run()
 	}
-}
-func (mt *mainThread) dispatch(fn func()) {
-	if mt.Running() {
-		fn()
-		return
-	}
-	id := mt.register(fn)
-	lib.g.IdleAdd(purego.NewCallback(func(ptr) int {
-		mt.Lock()
-		fn, exist := mt.fnMap[id]
-		if !exist {
-			mt.Unlock()
-			println("FATAL: main thread dispatch called with invalid id: " + strconv.Itoa(int(id)))
-			os.Exit(1)
-		}
-		delete(mt.fnMap, id)
-		mt.Unlock()
-		fn()
-		return 0 // gSourceRemove
-	}))
+	r.runnables = nil
 }
 
-func (mt *mainThread) InvokeSync(fn func()) {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	mt.dispatch(func() {
-		defer panicHandlerRecover()
-		fn()
-		wg.Done()
-	})
-	wg.Wait()
+type inflightCounter struct {
+	mutex sync.Mutex
+	n     int
+	idle  chan struct{}
 }
 
-func (mt *mainThread) InvokeAsync(fn func()) {
-	mt.dispatch(func() {
-		defer panicHandlerRecover()
-		fn()
-	})
+func (c *inflightCounter) add() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.n++
 }
 
-func (mt *mainThread) InvokeSyncWithResult(fn func() any) (res any) {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	mt.dispatch(func() {
-		defer panicHandlerRecover()
-		res = fn()
-		wg.Done()
-	})
-	wg.Wait()
-	return res
+func (c *inflightCounter) done() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.n--
+	if c.n == 0 && c.idle != nil {
+		close(c.idle)
+		c.idle = nil
+	}
 }
 
-func (mt *mainThread) InvokeSyncWithError(fn func() error) (err error) {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	mt.dispatch(func() {
-		defer panicHandlerRecover()
-		err = fn()
-		wg.Done()
-	})
-	wg.Wait()
-	return
+func (c *inflightCounter) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.n
 }
 
-func (mt *mainThread) InvokeSyncWithResultAndError(fn func() (any, error)) (res any, err error) {
-	var wg sync.WaitGroup
-	wg.Add(1)
-	mt.dispatch(func() {
-		defer panicHandlerRecover()
-		res, err = fn()
-		wg.Done()
-	})
-	wg.Wait()
-	return res, err
+// wait blocks until no work is in-flight or the timeout elapsed and reports if all work finished.
+func (c *inflightCounter) wait(timeout time.Duration) bool {
+	c.mutex.Lock()
+	if c.n == 0 {
+		c.mutex.Unlock()
+		return true
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	idle := c.idle
+	c.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
 }
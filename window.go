@@ -1,31 +1,19 @@
 package webkitgtk
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"github.com/ebitengine/purego"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"reflect"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unsafe"
 )
 
-var windowID uint
-var windowIDLock sync.RWMutex
-
-func getWindowID() uint {
-	windowIDLock.Lock()
-	defer windowIDLock.Unlock()
-	windowID++
-	return windowID
-}
-
 type WindowState int
 
 const (
@@ -54,9 +42,30 @@ type WindowOptions struct {
 	// JS to load after the page has loaded.
 	JS []string
 
-	// Define global Variables and APIs.
+	// Define global Variables and APIs. Struct pointers, funcs and maps of funcs are bound
+	// as APIs, any other value is defined as a JSON constant.
 	Define map[string]interface{}
 
+	// AllowedOrigins are the origins of the pages that may call the APIs of the window and
+	// get the API client, constants and events injected. An origin without host like app://
+	// allows all hosts of its scheme and "*" allows any page. Only the page itself may call
+	// the APIs, frames embedded in it can not. Default: app://
+	AllowedOrigins []string
+
+	// APIWorkers limits the number of calls running concurrently per API, further calls
+	// wait for a free worker. Structs implementing APIPolicy may have their own. Default: 16
+	APIWorkers int
+
+	// APITimeout rejects calls that do not return in time with a TimeoutError and cancels
+	// their context. It does not apply to streams, a negative timeout disables it.
+	// Default: 30s
+	APITimeout time.Duration
+
+	// APIMiddleware wraps the calls from JavaScript to the APIs of the window, e.g. to
+	// check permissions, log or rate limit calls. A middleware short-circuits a call by
+	// returning an error without calling next. The first middleware is the outermost.
+	APIMiddleware []func(next CallHandler) CallHandler
+
 	// Width is the starting width of the window.
 	Width int
 
@@ -103,6 +112,10 @@ type WindowOptions struct {
 	// Hidden will Hide the window when it is first created.
 	Hidden bool
 
+	// RememberState will save the size, position and state of the window when it is closed
+	// and restore it the next time a window with the same Name is opened.
+	RememberState bool
+
 	// Zoom is the initial Zoom level of the window.
 	Zoom float64
 
@@ -128,7 +141,7 @@ type WindowOptions struct {
 }
 
 type Window struct {
-	log        logFunc
+	log        *slog.Logger
 	options    WindowOptions
 	pointer    windowPtr
 	id         uint
@@ -140,9 +153,14 @@ type Window struct {
 
 	bindings  map[string]apiBinding
 	constants map[string]string
+	calls     apiCalls           // calls are the running api calls
+	token     string             // token authenticates the api client of the page, only accessed on the main thread
+	ctx       context.Context    // ctx is canceled when the page navigates away or the window closes
+	cancel    context.CancelFunc // cancel cancels ctx, must be called on the main thread
 }
 
-// Open opens a new window with the given options.
+// Open opens a new window with the given options. If a window with the same non-empty
+// Name is already open, that window is returned instead and no new window is created.
 func (a *App) Open(options WindowOptions) *Window {
 	if options.Width == 0 {
 		options.Width = 800
@@ -153,24 +171,32 @@ func (a *App) Open(options WindowOptions) *Window {
 	if options.Color == "" {
 		options.Color = "#FFFFFF"
 	}
+	if options.APIWorkers == 0 {
+		options.APIWorkers = 16
+	}
+	if options.APITimeout == 0 {
+		options.APITimeout = 30 * time.Second
+	}
+	if options.AllowedOrigins == nil {
+		options.AllowedOrigins = []string{uriScheme + "://"}
+	}
 
 	newWindow := &Window{
 		app:     a,
-		id:      getWindowID(),
 		options: options,
 	}
-	newWindow.log = newLogFunc("window-" + strconv.Itoa(int(newWindow.id)))
-
+	// the bindings are validated before the window is added, a panic must not leave a
+	// half built window behind
 	if options.Define != nil && len(options.Define) > 0 {
 		newWindow.constants = make(map[string]string)
 		newWindow.bindings = make(map[string]apiBinding)
 		for name, v := range options.Define {
-			value := reflect.ValueOf(v)
-			if value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct {
+			if apiBindable(v) {
 				binding, err := apiBind(v)
 				if err != nil {
 					panic(err)
 				}
+				binding.assignPools(options.APIWorkers)
 				newWindow.bindings[name] = binding
 			} else {
 				constant, err := json.Marshal(v)
@@ -182,6 +208,9 @@ func (a *App) Open(options WindowOptions) *Window {
 
 		}
 	}
+	if existing := a.addWindow(newWindow); existing != nil {
+		return existing
+	}
 
 	a.started.run(newWindow)
 	return newWindow
@@ -191,19 +220,18 @@ func (w *Window) ID() uint {
 	return w.id
 }
 
+// Name returns the unique name of the window given in the WindowOptions.
+func (w *Window) Name() string {
+	return w.options.Name
+}
+
 func (w *Window) run() {
 	w.app.thread.InvokeSync(w.create)
 }
 
 func (w *Window) create() {
-	id := w.ID()
-
-	w.app.windowsLock.Lock()
-	w.app.windows[id] = w
-	w.app.windowsLock.Unlock()
-
 	openTime := time.Now()
-	w.log("creating window", "id", w.id, "name", w.options.Name)
+	w.log.Debug("creating window", "id", w.id, "name", w.options.Name)
 
 	w.pointer = lib.gtk.ApplicationWindowNew(w.app.pointer)
 	lib.g.ObjectRefSink(ptr(w.pointer))
@@ -217,10 +245,7 @@ func (w *Window) create() {
 		if cacheDir == "" {
 			cacheDir = filepath.Join(lib.g.GetHomeDir(), ".cache", "webkitgtk", w.app.name)
 		}
-		dataDir := w.app.dataDir
-		if dataDir == "" {
-			dataDir = filepath.Join(lib.g.GetHomeDir(), ".local", "share", "webkitgtk", w.app.name)
-		}
+		dataDir := w.app.dataDirectory()
 		if w.app.ephemeral {
 			cacheDir = ""
 			dataDir = ""
@@ -246,39 +271,44 @@ func (w *Window) create() {
 		}
 
 		// 1.3. Configure app URI scheme and register it with the web webContext.
+		a := w.app
 		securityManager := lib.webkit.WebContextGetSecurityManager(w.app.webContext)
 		lib.webkit.SecurityManagerRegisterUriSchemeAsCorsEnabled(securityManager, uriScheme)
 		lib.webkit.SecurityManagerRegisterUriSchemeAsSecure(securityManager, uriScheme)
 		lib.webkit.WebContextRegisterUriScheme(
 			w.app.webContext,
 			uriScheme,
-			ptr(purego.NewCallback(func(request ptr) {
+			callbackFunc1(),
+			callbacks.register(func(request ptr) {
 				r := newUriSchemeRequest(request)
 				defer r.Close()
 
 				req, err := r.toHttpRequest()
 				if err != nil {
-					w.log("error parsing request", "error", err)
+					a.log.Error("error parsing request", "error", err)
 					return
 				}
 
 				rw := r.toResponseWriter()
+				if req.URL.Host == apiBlobHost {
+					a.blobs.serve(rw, req, a.blobOrigins(lib.webkit.UriSchemeRequestGetWebView(request)))
+					return
+				}
 				defer rw.Close()
 
-				w.app.handlerLock.RLock()
-				handler, exists := w.app.handler[req.URL.Host]
-				w.app.handlerLock.RUnlock()
+				a.handlerLock.RLock()
+				handler, exists := a.handler[req.URL.Host]
+				a.handlerLock.RUnlock()
 				if exists {
-					w.log("handler request", "host", req.URL.Host, "path", req.URL.Path)
+					a.log.Debug("handler request", "host", req.URL.Host, "path", req.URL.Path)
 					handler.ServeHTTP(rw, req)
 					return
 				}
 
-				w.log("no handler found for request", "host", req.URL.Host, "path", req.URL.Path)
+				a.log.Warn("no handler found for request", "host", req.URL.Host, "path", req.URL.Path)
 				http.Error(rw, "no handler found for request", http.StatusNotFound)
-			})),
-			0,
-			0,
+			}),
+			callbackDestroy(),
 		)
 	}
 
@@ -290,8 +320,11 @@ func (w *Window) create() {
 
 	// 3. Register the API handler if bindings are defined.
 	userContentManager := lib.webkit.WebViewGetUserContentManager(w.webview)
+	w.resetContext()
 	if w.bindings != nil {
-		userContentManager.registerScriptMessageHandler("api", apiHandler(w.bindings, w.ExecJS, w.log))
+		handler := apiChain(apiInvoke, w.app.middleware, w.options.APIMiddleware)
+		userContentManager.registerScriptMessageHandler("api", apiHandler(w, handler))
+		userContentManager.registerScriptMessageHandler("cancel", apiCancelHandler(w))
 	}
 
 	// 4. Apply the webkit settings to the webview.
@@ -415,7 +448,7 @@ func (w *Window) create() {
 	lib.gtk.ContainerAdd(w.pointer, w.vbox)
 	lib.gtk.WidgetSetName(w.vbox, "webview-box")
 	lib.gtk.BoxPackStart(w.vbox, ptr(w.webview), 1, 1, 0)
-	windowSetupSignalHandlers(w.id, w.pointer, w.webview)
+	windowSetupSignalHandlers(w)
 
 	// only set min/max GetSize if actually set
 	if w.options.MinWidth != 0 &&
@@ -434,6 +467,20 @@ func (w *Window) create() {
 		w.SetTitle(w.options.Title)
 	}
 
+	// restore the saved size and state of the window
+	state := w.loadState()
+	if state != nil {
+		w.options.Width, w.options.Height = state.Width, state.Height
+		switch {
+		case state.Fullscreen:
+			w.options.State = WindowStateFullscreen
+		case state.Maximised:
+			w.options.State = WindowStateMaximised
+		default:
+			w.options.State = WindowStateNormal
+		}
+	}
+
 	w.SetSize(w.options.Width, w.options.Height)
 	w.SetZoom(w.options.Zoom)
 	w.SetOverlay(w.options.Overlay)
@@ -445,12 +492,7 @@ func (w *Window) create() {
 	//}
 
 	w.SetFrameless(w.options.Frameless)
-
-	if w.options.X != 0 || w.options.Y != 0 {
-		w.setRelativePosition(w.options.X, w.options.Y)
-	} else {
-		w.Center()
-	}
+	w.initialPosition(state)
 
 	switch w.options.State {
 	case WindowStateMaximised:
@@ -473,37 +515,73 @@ func (w *Window) create() {
 
 	if !w.options.Hidden {
 		w.Show()
-		if w.options.X != 0 || w.options.Y != 0 {
-			w.setRelativePosition(w.options.X, w.options.Y)
-		} else {
-			w.Center() // needs to be queued until after GTK starts up!
-		}
+		w.initialPosition(state) // needs to be queued until after GTK starts up!
 	}
 	if w.options.DevToolsEnabled {
 		w.ToggleDevTools()
 	}
 
-	w.log("window created", "id", w.id, "name", w.options.Name, "since_open", time.Since(openTime))
+	w.log.Info("window created", "id", w.id, "name", w.options.Name, "since_open", time.Since(openTime))
+	w.app.hooks.invokeWindowOpened(w)
+}
+
+// resetContext cancels the api calls of the current page and creates a new context for
+// the next one, must be called on the main thread.
+func (w *Window) resetContext() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.calls.reset()
+	w.token = ""
+	w.ctx, w.cancel = context.WithCancel(w.app.ctx)
+}
+
+// initialPosition moves the window to the restored state position, the configured position or the center.
+func (w *Window) initialPosition(state *windowState) {
+	if state != nil {
+		w.restorePosition(state)
+	} else if w.options.X != 0 || w.options.Y != 0 {
+		w.setRelativePosition(w.options.X, w.options.Y)
+	} else {
+		w.Center()
+	}
+}
+
+// invoke runs fn on the main thread and waits for it to return. All public methods of the
+// window go through invoke so they can safely be called from any goroutine.
+func (w *Window) invoke(fn func()) {
+	w.app.thread.InvokeSync(fn)
 }
 
 func (w *Window) Focus() {
-	windowPresent(w.pointer)
+	w.invoke(func() {
+		windowPresent(w.pointer)
+	})
 }
 
 func (w *Window) Show() {
-	windowShow(w.pointer)
+	w.invoke(func() {
+		windowShow(w.pointer)
+	})
 }
 
 func (w *Window) Hide() {
-	windowHide(w.pointer)
+	w.invoke(func() {
+		windowHide(w.pointer)
+	})
 }
 
-func (w *Window) GetZoom() float64 {
-	return windowZoom(w.webview)
+func (w *Window) GetZoom() (zoom float64) {
+	w.invoke(func() {
+		zoom = windowZoom(w.webview)
+	})
+	return zoom
 }
 
 func (w *Window) SetZoom(zoom float64) {
-	windowZoomSet(w.webview, zoom)
+	w.invoke(func() {
+		windowZoomSet(w.webview, zoom)
+	})
 }
 
 // FIXME: this is not working properly
@@ -512,108 +590,157 @@ func (w *Window) SetZoom(zoom float64) {
 //}
 
 func (w *Window) ToggleDevTools() {
-	windowToggleDevTools(w.webview)
+	w.invoke(func() {
+		windowToggleDevTools(w.webview)
+	})
 }
 
-func (w *Window) GetSize() (int, int) {
-	return windowGetSize(w.pointer)
+func (w *Window) GetSize() (width int, height int) {
+	w.invoke(func() {
+		width, height = windowGetSize(w.pointer)
+	})
+	return width, height
 }
+
 func (w *Window) Unfullscreen() {
-	windowUnfullscreen(w.pointer)
-	w.Unmaximise()
+	w.invoke(func() {
+		windowUnfullscreen(w.pointer)
+		w.Unmaximise()
+	})
 }
 
 func (w *Window) Fullscreen() {
-	w.Maximise()
-	w.lastWidth, w.lastHeight = w.GetSize()
-	x, y, width, height, scale := windowGetCurrentMonitorGeometry(w.pointer)
-	if x == -1 && y == -1 && width == -1 && height == -1 {
-		return
-	}
-	w.SetMinMaxSize(0, 0, width*scale, height*scale)
-	w.SetSize(width*scale, height*scale)
-	windowFullscreen(w.pointer)
-	w.setRelativePosition(0, 0)
+	w.invoke(func() {
+		w.Maximise()
+		w.lastWidth, w.lastHeight = w.GetSize()
+		x, y, width, height, scale := windowGetCurrentMonitorGeometry(w.pointer)
+		if x == -1 && y == -1 && width == -1 && height == -1 {
+			return
+		}
+		w.SetMinMaxSize(0, 0, width*scale, height*scale)
+		w.SetSize(width*scale, height*scale)
+		windowFullscreen(w.pointer)
+		w.setRelativePosition(0, 0)
+	})
 }
 
 func (w *Window) Unminimise() {
-	windowPresent(w.pointer)
+	w.invoke(func() {
+		windowPresent(w.pointer)
+	})
 }
 
 func (w *Window) Unmaximise() {
-	lib.gtk.WindowUnmaximize(w.pointer)
+	w.invoke(func() {
+		lib.gtk.WindowUnmaximize(w.pointer)
+	})
 }
 
 func (w *Window) Maximise() {
-	windowMaximize(w.pointer)
+	w.invoke(func() {
+		windowMaximize(w.pointer)
+	})
 }
 
 func (w *Window) Minimise() {
-	windowMinimize(w.pointer)
+	w.invoke(func() {
+		windowMinimize(w.pointer)
+	})
 }
 
 func (w *Window) SetOverlay(alwaysOnTop bool) {
-	windowSetKeepAbove(w.pointer, alwaysOnTop)
+	w.invoke(func() {
+		windowSetKeepAbove(w.pointer, alwaysOnTop)
+	})
 }
 
 func (w *Window) SetTitle(title string) {
-	if !w.options.Frameless {
-		windowSetTitle(w.pointer, title)
+	if w.options.Frameless {
+		return
 	}
+	w.invoke(func() {
+		windowSetTitle(w.pointer, title)
+	})
 }
 
 func (w *Window) SetSize(width, height int) {
-	lib.gtk.WindowResize(w.pointer, width, height)
+	w.invoke(func() {
+		lib.gtk.WindowResize(w.pointer, width, height)
+	})
 }
 
 func (w *Window) ZoomIn() {
-	windowZoomIn(w.webview)
+	w.invoke(func() {
+		windowZoomIn(w.webview)
+	})
 }
 
 func (w *Window) ZoomOut() {
-	windowZoomOut(w.webview)
+	w.invoke(func() {
+		windowZoomOut(w.webview)
+	})
 }
 
 func (w *Window) ZoomReset() {
-	windowZoomSet(w.webview, 1.0)
+	w.invoke(func() {
+		windowZoomSet(w.webview, 1.0)
+	})
 }
 
 func (w *Window) Center() {
-	x, y, width, height, _ := windowGetCurrentMonitorGeometry(w.pointer)
-	if x == -1 && y == -1 && width == -1 && height == -1 {
-		return
-	}
-	windowWidth, windowHeight := windowGetSize(w.pointer)
+	w.invoke(func() {
+		x, y, width, height, _ := windowGetCurrentMonitorGeometry(w.pointer)
+		if x == -1 && y == -1 && width == -1 && height == -1 {
+			return
+		}
+		windowWidth, windowHeight := windowGetSize(w.pointer)
 
-	newX := ((width - int(windowWidth)) / 2) + x
-	newY := ((height - int(windowHeight)) / 2) + y
+		newX := ((width - int(windowWidth)) / 2) + x
+		newY := ((height - int(windowHeight)) / 2) + y
 
-	// Place the pointer at the Center of the monitor
-	windowMove(w.pointer, newX, newY)
+		// Place the pointer at the Center of the monitor
+		windowMove(w.pointer, newX, newY)
+	})
 }
 
 func (w *Window) SetFrameless(frameless bool) {
-	windowSetFrameless(w.pointer, frameless)
+	w.invoke(func() {
+		windowSetFrameless(w.pointer, frameless)
+	})
 }
 
-func (w *Window) IsMinimised() bool {
-	return windowIsMinimized(w.pointer)
+func (w *Window) IsMinimised() (minimised bool) {
+	w.invoke(func() {
+		minimised = windowIsMinimized(w.pointer)
+	})
+	return minimised
 }
 
-func (w *Window) IsMaximised() bool {
-	return windowIsMaximized(w.pointer)
+func (w *Window) IsMaximised() (maximised bool) {
+	w.invoke(func() {
+		maximised = windowIsMaximized(w.pointer)
+	})
+	return maximised
 }
 
-func (w *Window) IsFocused() bool {
-	return windowIsFocused(w.pointer)
+func (w *Window) IsFocused() (focused bool) {
+	w.invoke(func() {
+		focused = windowIsFocused(w.pointer)
+	})
+	return focused
 }
 
-func (w *Window) IsFullscreen() bool {
-	return windowIsFullscreen(w.pointer)
+func (w *Window) IsFullscreen() (fullscreen bool) {
+	w.invoke(func() {
+		fullscreen = windowIsFullscreen(w.pointer)
+	})
+	return fullscreen
 }
 
 func (w *Window) Close() {
-	windowClose(w.pointer)
+	w.invoke(func() {
+		windowClose(w.pointer)
+	})
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -703,14 +830,12 @@ func windowGetRelativePosition(window windowPtr) (int, int) {
 }
 
 func windowGetSize(window windowPtr) (int, int) {
-	// TODO: dispatchOnMainThread?
 	var width, height int
 	lib.gtk.WindowGetSize(window, &width, &height)
 	return width, height
 }
 
 func windowGetPosition(window windowPtr) (int, int) {
-	// TODO: dispatchOnMainThread?
 	var x, y int
 	lib.gtk.WindowGetPosition(window, &x, &y)
 	return x, y
@@ -756,9 +881,9 @@ func windowMinimize(window windowPtr) {
 }
 
 func (manager userContentManagerPtr) registerScriptMessageHandler(name string, handler func(string)) {
-	lib.g.SignalConnectObject(ptr(manager), "script-message-received::"+name, ptr(purego.NewCallback(func(manager ptr, message ptr) {
+	signalConnect(ptr(manager), "script-message-received::"+name, callbackFunc2(), func(manager ptr, message ptr) {
 		handler(lib.jsc.ValueToString(lib.webkit.JavascriptResultGetJsValue(message)))
-	})), 0, 0)
+	})
 	lib.webkit.UserContentManagerRegisterScriptMessageHandler(manager, name)
 }
 
@@ -840,50 +965,31 @@ func windowSetURL(webview webviewPtr, uri string) {
 	lib.webkit.WebViewLoadUri(webview, uri)
 }
 
-func windowSetupSignalHandlers(windowId uint, window windowPtr, webview webviewPtr) {
-	handleDelete := purego.NewCallback(func(ptr) {
-
-		_app.windowsLock.RLock()
-		appWindow := _app.windows[windowId]
-		_app.windowsLock.RUnlock()
-
-		if !appWindow.options.HideOnClose {
-			windowDestroy(window)
-			appWindow.log("pointer closed", "id", windowId, "name", appWindow.options.Name)
-
-			_app.windowsLock.Lock()
-			delete(_app.windows, windowId)
-			windowCount := len(_app.windows)
-			_app.windowsLock.Unlock()
-
-			if windowCount == 0 && !_app.hold {
-				_app.log("last window closed, quitting")
-				_app.Quit()
-			}
-		} else {
-			appWindow.log("pointer hiding", "id", windowId, "name", appWindow.options.Name)
-		}
+func windowSetupSignalHandlers(w *Window) {
+	signalConnect(ptr(w.pointer), "delete-event", callbackFunc2Int(), func(window ptr, event ptr) int {
+		return w.deleteEvent()
 	})
-	lib.g.SignalConnectData(ptr(window), "delete-event", handleDelete, 0, false, 0)
 
-	handleLoadChanged := purego.NewCallback(func(webview ptr, event int, data ptr) {
+	signalConnect(ptr(w.webview), "load-changed", callbackFunc2(), func(webview ptr, event ptr) {
 
 		switch event {
 		case 0: // LOAD_STARTED
+			w.resetContext()
 		case 1: // LOAD_REDIRECTED
 		case 2: // LOAD_COMMITTED
 		case 3: // LOAD_FINISHED
-			_app.windowsLock.RLock()
-			w := _app.windows[windowId]
-			_app.windowsLock.RUnlock()
+			w.log.Debug("initial load finished", "id", w.id, "name", w.options.Name)
 
-			w.log("initial load finished", "id", windowId, "name", w.options.Name)
-
-			for name, constant := range w.constants {
-				w.ExecJS(fmt.Sprintf("const %s = JSON.parse('%s');", name, constant))
+			if origin := apiOrigin(lib.webkit.WebViewGetUri(w.webview)); apiOriginAllowed(w.options.AllowedOrigins, origin) {
+				for name, constant := range w.constants {
+					w.ExecJS("const " + name + " = " + constant + ";") // JSON is a JavaScript literal
+				}
+				// TODO: this is not working properly
+				w.token = apiToken()
+				w.ExecJS(apiClient(w.bindings, w.token))
+			} else {
+				w.log.Warn("api not injected into page of disallowed origin", "origin", origin)
 			}
-			// TODO: this is not working properly
-			w.ExecJS(apiClient(w.bindings))
 
 			for _, css := range w.options.CSS {
 				w.AddCSS(css)
@@ -894,10 +1000,46 @@ func windowSetupSignalHandlers(windowId uint, window windowPtr, webview webviewP
 			}
 		}
 	})
-	lib.g.SignalConnectData(ptr(webview), "load-changed", handleLoadChanged, 0, false, 0)
 
 }
 
+// deleteEvent handles a request to close the window. It returns 1 to keep the window
+// and 0 to let the default handler of GTK destroy it.
+func (w *Window) deleteEvent() int {
+	a := w.app
+	if !w.options.HideOnClose {
+		a.windowsLock.RLock()
+		lastWindow := len(a.windows) == 1
+		a.windowsLock.RUnlock()
+		if lastWindow && !a.hold && !a.hooks.invokeBeforeQuit() {
+			w.log.Warn("pointer close canceled", "id", w.id, "name", w.options.Name)
+			return 1 // stop other handlers from destroying the window
+		}
+
+		w.saveState()
+		w.cancel()
+		w.log.Info("pointer closed", "id", w.id, "name", w.options.Name)
+
+		a.windowsLock.Lock()
+		delete(a.windows, w.id)
+		windowCount := len(a.windows)
+		a.windowsLock.Unlock()
+		a.hooks.invokeWindowClosed(w)
+
+		if windowCount == 0 && !a.hold {
+			a.log.Info("last window closed, quitting")
+			a.Quit()
+		}
+		// gtk_window_close would queue another delete event, the default handler
+		// destroys the window instead
+		return 0
+	}
+	w.saveState()
+	windowHide(w.pointer)
+	w.log.Info("pointer hidden", "id", w.id, "name", w.options.Name)
+	return 1 // stop the default handler from destroying the window
+}
+
 func windowToggleDevTools(webview webviewPtr) {
 	settings := lib.webkit.WebViewGetSettings(webview)
 	lib.webkitSettings.SetEnableDeveloperExtras(
@@ -945,8 +1087,10 @@ func (w *Window) isVisible() bool {
 }
 
 func (w *Window) DisableSizeConstraints() {
-	x, y, width, height, scale := windowGetCurrentMonitorGeometry(w.pointer)
-	w.SetMinMaxSize(x, y, width*scale, height*scale)
+	w.invoke(func() {
+		x, y, width, height, scale := windowGetCurrentMonitorGeometry(w.pointer)
+		w.SetMinMaxSize(x, y, width*scale, height*scale)
+	})
 }
 
 func (w *Window) Restore() {
@@ -955,7 +1099,12 @@ func (w *Window) Restore() {
 }
 
 func (w *Window) ExecJS(js string) {
-	windowExecJS(w.webview, js)
+	w.invoke(func() {
+		if w.webview == 0 {
+			return // not created yet
+		}
+		windowExecJS(w.webview, js)
+	})
 }
 
 func (w *Window) AddCSS(css string) {
@@ -973,11 +1122,15 @@ func (w *Window) SetURL(uri string) {
 			uri = url.String()
 		}
 	}
-	windowSetURL(w.webview, uri)
+	w.invoke(func() {
+		windowSetURL(w.webview, uri)
+	})
 }
 
 func (w *Window) SetHTML(html string) {
-	lib.webkit.WebViewLoadHtml(w.webview, html, uriScheme+"://")
+	w.invoke(func() {
+		lib.webkit.WebViewLoadHtml(w.webview, html, uriScheme+"://")
+	})
 }
 
 func (w *Window) SetMinMaxSize(minWidth, minHeight, maxWidth, maxHeight int) {
@@ -993,7 +1146,9 @@ func (w *Window) SetMinMaxSize(minWidth, minHeight, maxWidth, maxHeight int) {
 	if maxHeight == 0 {
 		maxHeight = -1
 	}
-	windowSetGeometryHints(w.pointer, minWidth, minHeight, maxWidth, maxHeight)
+	w.invoke(func() {
+		windowSetGeometryHints(w.pointer, minWidth, minHeight, maxWidth, maxHeight)
+	})
 }
 
 func (w *Window) SetMinSize(width, height int) {
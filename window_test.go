@@ -0,0 +1,149 @@
+package webkitgtk
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+func TestWindowAccessorsConcurrent(t *testing.T) {
+	l := newTestLoop(t)
+
+	// the native window is only safe to access on the main thread
+	var native struct {
+		width, height int
+		title         string
+		zoom          float64
+	}
+	stubLib(t, &lib.gtk.WindowGetSize, func(_ windowPtr, width, height *int) {
+		l.onMainThread(t, "gtk_window_get_size")
+		*width, *height = native.width, native.height
+	})
+	stubLib(t, &lib.gtk.WindowResize, func(_ windowPtr, width, height int) {
+		l.onMainThread(t, "gtk_window_resize")
+		native.width, native.height = width, height
+	})
+	stubLib(t, &lib.gtk.WindowSetTitle, func(_ windowPtr, title string) {
+		l.onMainThread(t, "gtk_window_set_title")
+		native.title = title
+	})
+	stubLib(t, &lib.webkit.WebViewGetZoomLevel, func(webviewPtr) float64 {
+		l.onMainThread(t, "webkit_web_view_get_zoom_level")
+		return native.zoom
+	})
+	stubLib(t, &lib.webkit.WebViewSetZoomLevel, func(_ webviewPtr, zoom float64) {
+		l.onMainThread(t, "webkit_web_view_set_zoom_level")
+		native.zoom = zoom
+	})
+
+	a := &App{thread: l.mt, windows: make(map[uint]*Window)}
+	w := &Window{app: a, id: 1, pointer: 1, webview: 1}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				w.SetSize(g, i)
+				w.GetSize()
+				w.SetTitle("window")
+				w.SetZoom(float64(i))
+				w.GetZoom()
+				w.ZoomReset()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if width, height := w.GetSize(); width < 0 || width >= 8 || height != 199 {
+		t.Errorf("size is %dx%d after the last resize", width, height)
+	}
+}
+
+func TestWindowDeleteEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		hideOnClose bool
+		veto        bool
+		closed      int
+		hidden      int
+		destroyed   int
+		quit        int
+	}{
+		{name: "close", closed: 1, destroyed: 1, quit: 1},
+		{name: "hide", hideOnClose: true, hidden: 1},
+		{name: "veto", veto: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			l := newTestLoop(t)
+			log := slog.New(discardHandler{})
+			a := &App{log: log, thread: l.mt, windows: make(map[uint]*Window)}
+			a.ctx, a.cancel = context.WithCancel(context.Background())
+			t.Cleanup(a.cancel)
+			w := &Window{log: log, app: a, id: 1, pointer: 1, options: WindowOptions{HideOnClose: test.hideOnClose}}
+			a.windows[w.id] = w
+			l.mt.InvokeSync(w.resetContext)
+
+			var closed, hidden, destroyed, quit, deleteEvents int
+			a.OnWindowClosed(func(*Window) {
+				closed++
+			})
+			a.OnBeforeQuit(func() bool {
+				return !test.veto
+			})
+			// gtk_window_close emits the delete event, the window is destroyed by the
+			// default handler unless the signal handler returns 1
+			stubLib(t, &lib.gtk.WindowClose, func(windowPtr) {
+				l.onMainThread(t, "gtk_window_close")
+				if deleteEvents++; deleteEvents > 1 {
+					t.Error("delete event emitted again while closing the window")
+					return
+				}
+				if w.deleteEvent() == 0 {
+					destroyed++
+				}
+			})
+			stubLib(t, &lib.gtk.WidgetHide, func(ptr) {
+				hidden++
+			})
+			stubLib(t, &lib.g.ApplicationQuit, func(ptr) {
+				quit++
+			})
+
+			w.Close()
+
+			if closed != test.closed || hidden != test.hidden || destroyed != test.destroyed || quit != test.quit {
+				t.Errorf("closed %d, hidden %d, destroyed %d and quit %d times, want %d, %d, %d and %d",
+					closed, hidden, destroyed, quit, test.closed, test.hidden, test.destroyed, test.quit)
+			}
+			if _, ok := a.windows[w.id]; ok != (test.closed == 0) {
+				t.Errorf("window registered: %t", ok)
+			}
+		})
+	}
+}
+
+func TestOpenInvalidDefine(t *testing.T) {
+	a := &App{logger: slog.New(discardHandler{}), windows: make(map[uint]*Window)}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Open did not panic on a constant that can not be marshalled")
+			}
+		}()
+		a.Open(WindowOptions{Name: "main", Define: map[string]interface{}{"invalid": make(chan int)}})
+	}()
+	if w := a.Window("main"); w != nil {
+		t.Fatalf("window %d registered by a failed Open", w.ID())
+	}
+
+	w := a.Open(WindowOptions{Name: "main"})
+	if w.log == nil || a.Window("main") != w {
+		t.Fatal("window not registered by the next Open")
+	}
+}